@@ -0,0 +1,44 @@
+package cmd
+
+import "time"
+
+// clock is the source of wall-clock time and timers for scheduling emits, so
+// replay timing can be driven by something other than the system clock.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) replayTimer
+}
+
+// replayTimer is the subset of *time.Timer that emitters use.
+type replayTimer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// wallClock is the system clock.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) NewTimer(d time.Duration) replayTimer {
+	return wallTimer{time.NewTimer(d)}
+}
+
+type wallTimer struct {
+	t *time.Timer
+}
+
+func (wt wallTimer) C() <-chan time.Time {
+	return wt.t.C
+}
+
+func (wt wallTimer) Reset(d time.Duration) bool {
+	return wt.t.Reset(d)
+}
+
+func (wt wallTimer) Stop() bool {
+	return wt.t.Stop()
+}
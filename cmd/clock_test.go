@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock whose timers fire as soon as they're armed, moving the
+// clock forward to their deadline. A replay scheduled on it runs instantly,
+// and every emit sees the time it was due as Now.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) replayTimer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// advance moves the clock forward by d, never backward, and returns the new
+// time.
+func (c *fakeClock) advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	return c.now
+}
+
+// fakeTimer is a timer of a fakeClock. It has always fired by the time Reset
+// returns, so Stop never stops it.
+type fakeTimer struct {
+	clock *fakeClock
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	now := t.clock.advance(d)
+	select {
+	case t.c <- now:
+	default:
+	}
+	return false
+}
+
+func (t *fakeTimer) Stop() bool {
+	return false
+}
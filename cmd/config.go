@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var configFlag string
+
+// configFlags holds the names of flags set from the --config file. They count
+// as changed, like flags given on the command line, so checks for an explicit
+// setting see them.
+var configFlags = map[string]bool{}
+
+// applyConfig sets every flag of cmd not given on the command line from the
+// file named by --config. It's run before any command so config values are
+// checked along with the command line.
+func applyConfig(cmd *cobra.Command, args []string) error {
+	if configFlag == "" {
+		return nil
+	}
+	values, err := readConfig(configFlag)
+	if err != nil {
+		return fmt.Errorf("--config: %v", err)
+	}
+	for _, kv := range values {
+		f := cmd.Flags().Lookup(kv.name)
+		if f == nil {
+			if !isFlag(rootCmd, kv.name) {
+				return fmt.Errorf("--config: %s:%d: unknown flag %q", configFlag, kv.line, kv.name)
+			}
+			// A flag of another subcommand, e.g. validate's --strict
+			continue
+		}
+		if f.Changed && !configFlags[kv.name] {
+			// Command line wins, including every value of repeatable flags
+			continue
+		}
+		for _, v := range kv.values {
+			if err := cmd.Flags().Set(kv.name, v); err != nil {
+				return fmt.Errorf("--config: %s:%d: %s: %v", configFlag, kv.line, kv.name, err)
+			}
+		}
+		// Later lines for a repeatable flag add to it
+		configFlags[kv.name] = true
+	}
+	return nil
+}
+
+// isFlag reports whether name is a flag of cmd or any of its subcommands.
+func isFlag(cmd *cobra.Command, name string) bool {
+	if cmd.Flags().Lookup(name) != nil || cmd.PersistentFlags().Lookup(name) != nil {
+		return true
+	}
+	for _, sub := range cmd.Commands() {
+		if isFlag(sub, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// configValue is one flag setting from a config file.
+type configValue struct {
+	name   string
+	values []string // more than one only for a list
+	line   int
+}
+
+// readConfig reads a config file of flag settings. It accepts only this
+// subset of YAML and TOML, and reports anything else with its line number:
+//
+//   - blank lines, # comments, and a leading --- document start
+//   - one setting per line, unindented, as "name: value" or "name = value",
+//     where name is a flag name without the dashes, with underscores read as
+//     dashes, and a YAML colon is followed by a space
+//   - a value that's double-quoted with Go escapes, single-quoted and
+//     literal, or bare up to a " #" comment
+//   - a [a, b] list of such values, on one line, for repeatable flags like
+//     --generic, which may also be set on several lines
+//
+// Bare values can't contain ": " or start with a character YAML treats
+// specially, so the file reads the same in a YAML parser.
+func readConfig(path string) (values []configValue, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		raw := sc.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "---" && len(values) == 0 {
+			continue
+		}
+		bad := func(format string, v ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", path, lineNum, fmt.Sprintf(format, v...))
+		}
+		switch {
+		case line == "---":
+			return nil, bad("only one YAML document is supported")
+		case raw[0] == ' ' || raw[0] == '\t':
+			return nil, bad("indented lines aren't supported, put every flag at the top level on one line")
+		case strings.HasPrefix(line, "["):
+			return nil, bad("tables aren't supported, put every flag at the top level")
+		case line == "-" || strings.HasPrefix(line, "- "):
+			return nil, bad("block lists aren't supported, use [a, b]")
+		}
+		sep := strings.IndexAny(line, ":=")
+		if sep <= 0 || !isConfigName(strings.TrimSpace(line[:sep])) {
+			return nil, bad("want name: value or name = value")
+		}
+		if line[sep] == ':' && sep+1 < len(line) && line[sep+1] != ' ' && line[sep+1] != '\t' {
+			return nil, bad("want a space after the colon")
+		}
+		name := strings.ReplaceAll(strings.TrimSpace(line[:sep]), "_", "-")
+		value := strings.TrimSpace(line[sep+1:])
+		if value == "" || strings.HasPrefix(value, "#") {
+			return nil, bad("%s has no value, nested settings and block lists aren't supported", name)
+		}
+		vals, err := parseConfigValue(value)
+		if err != nil {
+			return nil, bad("%s: %v", name, err)
+		}
+		values = append(values, configValue{name: name, values: vals, line: lineNum})
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// isConfigName reports whether name is made of the letters, digits, dashes,
+// and underscores of a flag name.
+func isConfigName(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// parseConfigValue parses a config value, a scalar or a [list] of scalars,
+// dropping any trailing comment.
+func parseConfigValue(s string) (vals []string, err error) {
+	if !strings.HasPrefix(s, "[") {
+		v, rest, err := parseConfigScalar(s, false)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return []string{v}, nil
+	}
+	s = strings.TrimSpace(s[1:])
+	for {
+		if strings.HasPrefix(s, "]") {
+			break
+		}
+		v, rest, err := parseConfigScalar(s, true)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+		switch {
+		case strings.HasPrefix(rest, ","):
+			s = strings.TrimSpace(rest[1:])
+		case strings.HasPrefix(rest, "]"):
+			s = rest
+		default:
+			return nil, fmt.Errorf("unterminated list")
+		}
+	}
+	if rest := strings.TrimSpace(s[1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return nil, fmt.Errorf("unexpected %q after list", rest)
+	}
+	return vals, nil
+}
+
+// parseConfigScalar parses a quoted or bare value from the start of s and
+// returns it with the rest of s. Double-quoted values use Go escapes, single
+// quotes are literal. A bare value ends at a comment, or in a list at a comma
+// or closing bracket.
+func parseConfigScalar(s string, inList bool) (v string, rest string, err error) {
+	switch {
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''"):
+		return "", "", fmt.Errorf("multi-line strings aren't supported")
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				if v, err = strconv.Unquote(s[:i+1]); err != nil {
+					return "", "", fmt.Errorf("bad quoted value %s", s[:i+1])
+				}
+				return v, strings.TrimSpace(s[i+1:]), nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated quoted value %s", s)
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quoted value %s", s)
+		}
+		return s[1 : end+1], strings.TrimSpace(s[end+2:]), nil
+	}
+	end := len(s)
+	for i := 0; i < len(s); i++ {
+		if (s[i] == '#' && (i == 0 || s[i-1] == ' ')) || (inList && (s[i] == ',' || s[i] == ']')) {
+			end = i
+			break
+		}
+	}
+	v = strings.TrimSpace(s[:end])
+	if v != "" && strings.ContainsRune("[]{}&*!|>%@`", rune(v[0])) {
+		return "", "", fmt.Errorf("quote the value %s, it starts with %q", v, v[0])
+	}
+	if strings.Contains(v, ": ") {
+		return "", "", fmt.Errorf(`quote the value %s, it contains ": "`, v)
+	}
+	return v, strings.TrimSpace(s[end:]), nil
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// configTestCmd returns a command with a few flags like the replay's, and
+// points --config at a file holding config.
+func configTestCmd(t *testing.T, config string) (cmd *cobra.Command, warp *float64, generic *[]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "replay.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	origFile, origSet := configFlag, configFlags
+	t.Cleanup(func() { configFlag, configFlags = origFile, origSet })
+	configFlag, configFlags = path, map[string]bool{}
+
+	cmd = &cobra.Command{Use: "test"}
+	warp = cmd.Flags().Float64("warp", 1, "")
+	generic = cmd.Flags().StringArray("generic", nil, "")
+	return cmd, warp, generic
+}
+
+func TestApplyConfigCountsAsChanged(t *testing.T) {
+	cmd, warp, generic := configTestCmd(t, "warp: 10\ngeneric: a.tsv\ngeneric: b.tsv\n")
+	if err := applyConfig(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if *warp != 10 {
+		t.Errorf("warp = %v, want 10", *warp)
+	}
+	if !cmd.Flags().Changed("warp") {
+		t.Error("warp from the config isn't changed, so --finish-by would override it")
+	}
+	if want := []string{"a.tsv", "b.tsv"}; !reflect.DeepEqual(*generic, want) {
+		t.Errorf("generic = %q, want %q", *generic, want)
+	}
+}
+
+func TestApplyConfigCommandLineWins(t *testing.T) {
+	cmd, warp, generic := configTestCmd(t, "warp: 10\ngeneric: [a.tsv, b.tsv]\n")
+	if err := cmd.Flags().Parse([]string{"--warp", "2", "--generic", "c.tsv"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if *warp != 2 {
+		t.Errorf("warp = %v, want the command line's 2", *warp)
+	}
+	if want := []string{"c.tsv"}; !reflect.DeepEqual(*generic, want) {
+		t.Errorf("generic = %q, want only the command line's %q", *generic, want)
+	}
+}
+
+// writeConfig writes config to a file for the rest of the test and returns
+// its path.
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "replay.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	path := writeConfig(t, `---
+# A replay
+evt: evt
+warp = 10   # sped up
+lag_warn: 2s
+start: 2021-01-01T00:00:00Z
+  # an indented comment
+underway: "under way.txt"
+heartbeat: '60s:$PING # not a comment'
+empty: ""
+generic: ['ctd.tsv:0:2006-01-02 15:04:05:ctd/ctd.tsv', b.tsv]
+glob-feed = ["a/*.png:png:2006:\\d+"]
+none: []
+`)
+	values, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []configValue{
+		{"evt", []string{"evt"}, 3},
+		{"warp", []string{"10"}, 4},
+		{"lag-warn", []string{"2s"}, 5},
+		{"start", []string{"2021-01-01T00:00:00Z"}, 6},
+		{"underway", []string{"under way.txt"}, 8},
+		{"heartbeat", []string{"60s:$PING # not a comment"}, 9},
+		{"empty", []string{""}, 10},
+		{"generic", []string{"ctd.tsv:0:2006-01-02 15:04:05:ctd/ctd.tsv", "b.tsv"}, 11},
+		{"glob-feed", []string{`a/*.png:png:2006:\d+`}, 12},
+		{"none", nil, 13},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("read %v, want %v", values, want)
+	}
+}
+
+func TestReadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string // after path:line:
+	}{
+		{"nested mapping", "evt: evt\nudp:\n  host: 10.0.0.1\n", "2: udp has no value"},
+		{"indented setting", "evt: evt\n  warp: 10\n", "2: indented lines aren't supported"},
+		{"continued value", "generic: [a.tsv,\n  b.tsv]\n", "1: generic: unterminated list"},
+		{"block list", "generic:\n- a.tsv\n", "1: generic has no value"},
+		{"block list item", "# list\n- a.tsv\n", "2: block lists aren't supported"},
+		{"TOML table", "evt = \"evt\"\n[udp]\nhost = \"10.0.0.1\"\n", "2: tables aren't supported"},
+		{"TOML array of tables", "[[generic]]\n", "1: tables aren't supported"},
+		{"TOML dotted key", "udp.host = \"10.0.0.1\"\n", "1: want name: value or name = value"},
+		{"quoted key", "\"warp\": 10\n", "1: want name: value or name = value"},
+		{"no separator", "evt evt\n", "1: want name: value or name = value"},
+		{"no space after colon", "warp:10\n", "1: want a space after the colon"},
+		{"second document", "evt: evt\n---\nwarp: 10\n", "2: only one YAML document is supported"},
+		{"block scalar", "heartbeat: |\n  60s\n", "1: heartbeat: quote the value |"},
+		{"folded scalar", "heartbeat: >-\n", "1: heartbeat: quote the value >-"},
+		{"flow mapping", "udp: {host: 10.0.0.1}\n", "1: udp: quote the value {host"},
+		{"anchor", "evt: &dir evt\n", "1: evt: quote the value &dir evt"},
+		{"alias", "underway: *.txt\n", "1: underway: quote the value *.txt"},
+		{"tag", "warp: !!float 10\n", "1: warp: quote the value !!float 10"},
+		{"nested list", "generic: [[a.tsv]]\n", "1: generic: quote the value [a.tsv"},
+		{"colon space in bare value", "heartbeat: 60s:note: ping\n", `1: heartbeat: quote the value 60s:note: ping, it contains ": "`},
+		{"unterminated double quote", "evt: \"evt\n", "1: evt: unterminated quoted value"},
+		{"unterminated single quote", "evt: 'evt\n", "1: evt: unterminated quoted value"},
+		{"YAML escaped single quote", "evt: 'it''s'\n", "1: evt: unexpected \"'s'\" after value"},
+		{"TOML multi-line string", "heartbeat = \"\"\"\n60s\n\"\"\"\n", "1: heartbeat: multi-line strings aren't supported"},
+		{"text after a list", "generic: [a.tsv] b.tsv\n", "1: generic: unexpected \"b.tsv\" after list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.config)
+			_, err := readConfig(path)
+			if want := path + ":" + tt.wantErr; err == nil || !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("error = %v, want %s", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// reportGaps logs every interval longer than threshold between consecutive
+// records of each emitter.
+func reportGaps(es []feeds.Emitter, threshold time.Duration) {
+	for _, e := range es {
+		ts := e.Times()
+		n := 0
+		for i := 1; i < len(ts); i++ {
+			if gap := ts[i].Sub(ts[i-1]); gap > threshold {
+				logger.Printf("%v gap from %v to %v (%v)\n", e.Name(), formatTime(ts[i-1]), formatTime(ts[i]), gap)
+				n++
+			}
+		}
+		logger.Printf("%v has %d gaps longer than %v\n", e.Name(), n, threshold)
+	}
+}
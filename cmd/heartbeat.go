@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// defaultHeartbeat is the --udp-heartbeat payload when none is given.
+const defaultHeartbeat = "HEARTBEAT"
+
+// parseHeartbeatSpec parses an --udp-heartbeat value of the form
+// interval[:payload]. The payload gets a trailing newline like underway
+// records if it doesn't have one.
+func parseHeartbeatSpec(spec string) (interval time.Duration, payload string, err error) {
+	intervalStr := spec
+	payload = defaultHeartbeat
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		intervalStr, payload = spec[:i], spec[i+1:]
+		if payload == "" {
+			return 0, "", fmt.Errorf("%q has an empty payload", spec)
+		}
+	}
+	if interval, err = time.ParseDuration(intervalStr); err != nil {
+		return 0, "", err
+	}
+	if interval <= 0 {
+		return 0, "", fmt.Errorf("%q: interval must be positive", spec)
+	}
+	if !strings.HasSuffix(payload, "\n") {
+		payload += "\n"
+	}
+	return interval, payload, nil
+}
+
+// findUnderway returns the underway feed in es, or nil if there isn't one.
+func findUnderway(es []feeds.Emitter) *feeds.Underway {
+	for _, e := range es {
+		if u, ok := e.(*feeds.Underway); ok {
+			return u
+		}
+	}
+	return nil
+}
+
+// sendHeartbeats sends payload on the underway feed's connection every
+// interval of wall-clock time until ctx is cancelled, whether or not data is
+// due. Failed sends are logged and don't stop the heartbeat.
+func sendHeartbeats(ctx context.Context, u *feeds.Underway, interval time.Duration, payload string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := u.SendHeartbeat(payload); err != nil {
+				logger.Warnf("%v\n", err)
+			}
+		}
+	}
+}
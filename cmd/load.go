@@ -0,0 +1,374 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// setupLogger applies --log-format, --verbosity, and --quiet.
+func setupLogger() {
+	if err := logger.setFormat(logFormatFlag); err != nil {
+		logger.Fatalf("error: --log-format: %v\n", err)
+	}
+	if err := logger.setLevel(verbosityFlag); err != nil {
+		logger.Fatalf("error: --verbosity: %v\n", err)
+	}
+	logger.quiet = quietFlag
+}
+
+// parseFeedOptions builds the options shared by every feed from the command
+// line, logging each flag.
+func parseFeedOptions() (feedOpts feeds.Options) {
+	var err error
+	feedOpts.Shift = shiftTimeFlag
+	logger.Detailf("--shift-time = %v\n", shiftTimeFlag)
+	if tzFlag != "" {
+		if feedOpts.TZ, err = time.LoadLocation(tzFlag); err != nil {
+			logger.Fatalf("error: --tz: %v\n", err)
+		}
+	}
+	logger.Detailf("--tz = %v\n", tzFlag)
+	if filterFromFlag != "" {
+		feedOpts.From, err = time.Parse(time.RFC3339, filterFromFlag)
+		if err != nil {
+			logger.Fatalf("error: --filter-from: %v\n", err)
+		}
+	}
+	logger.Detailf("--filter-from = %v\n", filterFromFlag)
+	if filterToFlag != "" {
+		feedOpts.To, err = time.Parse(time.RFC3339, filterToFlag)
+		if err != nil {
+			logger.Fatalf("error: --filter-to: %v\n", err)
+		}
+		if !feedOpts.From.IsZero() && !feedOpts.To.After(feedOpts.From) {
+			logger.Fatalf("error: --filter-to must be after --filter-from\n")
+		}
+	}
+	logger.Detailf("--filter-to = %v\n", filterToFlag)
+	if pathTemplateFlag != "" {
+		if feedOpts.Paths, err = feeds.ParsePathTemplate(pathTemplateFlag); err != nil {
+			logger.Fatalf("error: --path-template: %v\n", err)
+		}
+	}
+	logger.Detailf("--path-template = %v\n", pathTemplateFlag)
+	if feedOpts.DirMode, err = parseMode(dirModeFlag); err != nil {
+		logger.Fatalf("error: --dir-mode: %v\n", err)
+	}
+	logger.Detailf("--dir-mode = %04o\n", feedOpts.DirMode)
+	if feedOpts.FileMode, err = parseMode(fileModeFlag); err != nil {
+		logger.Fatalf("error: --file-mode: %v\n", err)
+	}
+	logger.Detailf("--file-mode = %04o\n", feedOpts.FileMode)
+	if maxCopiesFlag < 0 {
+		logger.Fatalf("error: --max-copies must not be negative\n")
+	}
+	if maxCopiesFlag > 0 {
+		feedOpts.Copies = feeds.NewCopyLimiter(maxCopiesFlag)
+	}
+	logger.Detailf("--max-copies = %v\n", maxCopiesFlag)
+	if copyBufferFlag < 0 {
+		logger.Fatalf("error: --copy-buffer must not be negative\n")
+	}
+	feedOpts.CopyBuf = copyBufferFlag
+	logger.Detailf("--copy-buffer = %v\n", copyBufferFlag)
+	if maxWarningsFlag < 0 {
+		logger.Fatalf("error: --max-warnings must not be negative\n")
+	}
+	feedOpts.MaxWarnings = maxWarningsFlag
+	if evtSampleFlag < 0 || underwaySampleFlag < 0 {
+		logger.Fatalf("error: --evt-sample and --underway-sample must not be negative\n")
+	}
+	logger.Detailf("--max-warnings = %v\n", maxWarningsFlag)
+	feedOpts.Dedupe = dedupeFlag
+	logger.Detailf("--dedupe = %v\n", dedupeFlag)
+	return feedOpts
+}
+
+// duplicateCounter is a feed that can drop duplicate records with --dedupe.
+type duplicateCounter interface {
+	Duplicates() int
+}
+
+// parseMode parses an octal permission mode such as 0644.
+func parseMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m == 0 || m > 0777 {
+		return 0, fmt.Errorf("%q is not an octal permission mode like 0644", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// loadEmitters reads every feed requested on the command line, logging any
+// warnings and writing them to --warnings-file. If discard is true the underway
+// and SFL feeds don't open their network or serial destinations or the
+// --udp-tee file.
+func loadEmitters(feedOpts feeds.Options, discard bool) (emitters []feeds.Emitter) {
+	emitters = []feeds.Emitter{}
+
+	// EVT feed
+	if evtDirFlag != "" {
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("Reading EVT data\n")
+		logger.Detailf("-------------------------------------------------------\n")
+		evtOpts := feeds.EvtOptions{
+			Options:   feedOpts,
+			Link:      evtLinkFlag,
+			MtimeSkew: evtMtimeSkewFlag,
+			Sample:    evtSampleFlag,
+			NoOutput:  !evtEmitFlag,
+		}
+		var evtData *feeds.Evt
+		var err error
+		archive := feeds.IsTarArchive(evtDirFlag)
+		if archive {
+			evtData, err = feeds.NewEvtArchive(evtDirFlag, outDirFlag, evtOpts)
+		} else {
+			var evtFiles []string
+			if evtFiles, err = feeds.FindEVTFiles(evtDirFlag); err != nil {
+				logger.Fatalf("%v", err)
+			}
+			evtData, err = feeds.NewEvt(evtFiles, outDirFlag, evtOpts)
+		}
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if len(evtData.Warnings()) > 0 {
+			for _, w := range evtData.Warnings() {
+				logger.Warnf("%v", w)
+			}
+			logger.Detailf("-------------------------------------------------------\n")
+		}
+		logger.Detailf("\n")
+		emitters = append(emitters, evtData)
+
+		// SFL feed, not read from archives
+		if !archive {
+			logger.Detailf("-------------------------------------------------------\n")
+			logger.Detailf("Reading SFL data\n")
+			logger.Detailf("-------------------------------------------------------\n")
+			sflFiles, err := feeds.FindSFLFiles(evtDirFlag)
+			if err != nil {
+				logger.Fatalf("%v", err)
+			}
+			sflOpts := feeds.SflOptions{
+				Options:  feedOpts,
+				Compress: compressSflFlag,
+				Fsync:    fsyncFlag,
+			}
+			if sflUDPFlag != "" {
+				dest, err := parseUDPSpec(sflUDPFlag)
+				if err != nil {
+					logger.Fatalf("error: --sfl-udp: %v\n", err)
+				}
+				dest.Discard = discard
+				sflOpts.UDP = &dest
+				sflOpts.NoFiles = sflUDPOnlyFlag
+			} else if sflUDPOnlyFlag {
+				logger.Fatalf("error: --sfl-udp-only requires --sfl-udp\n")
+			}
+			sflData, err := feeds.NewSfl(sflFiles, outDirFlag, sflOpts)
+			if err != nil {
+				logger.Fatalf("%v", err)
+			}
+			if len(sflData.Warnings()) > 0 {
+				for _, w := range sflData.Warnings() {
+					logger.Warnf("%v", w)
+				}
+				logger.Detailf("-------------------------------------------------------\n")
+			}
+			logger.Detailf("\n")
+			emitters = append(emitters, sflData)
+		}
+	}
+
+	if oppDirFlag != "" {
+		// OPP feed
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("Reading OPP data\n")
+		logger.Detailf("-------------------------------------------------------\n")
+		oppFiles, err := feeds.FindOPPFiles(oppDirFlag)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		oppData, err := feeds.NewOpp(oppFiles, outDirFlag, feedOpts)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if len(oppData.Warnings()) > 0 {
+			for _, w := range oppData.Warnings() {
+				logger.Warnf("%v", w)
+			}
+			logger.Detailf("-------------------------------------------------------\n")
+		}
+		logger.Detailf("\n")
+		emitters = append(emitters, oppData)
+	}
+
+	if underwayFileFlag != "" {
+		// Underway feed
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("Reading underway data\n")
+		logger.Detailf("-------------------------------------------------------\n")
+		var dest feeds.Transport
+		if underwayOutFlag == "stdout" {
+			dest = feeds.Transport{Proto: "stdout"}
+		} else if underwayOutFlag != "" {
+			var err error
+			if dest, err = parseSerialSpec(underwayOutFlag); err != nil {
+				logger.Fatalf("error: --underway-out: %v\n", err)
+			}
+		} else {
+			if protoFlag != "udp" && protoFlag != "tcp" {
+				logger.Fatalf("error: --proto must be udp or tcp\n")
+			}
+			if protoFlag == "tcp" && net.ParseIP(udpHostFlag).Equal(net.IPv4bcast) {
+				logger.Fatalf("error: --host must be a real IP address with --proto tcp, not the broadcast address\n")
+			}
+			dest = feeds.Transport{
+				Proto:     protoFlag,
+				Host:      udpHostFlag,
+				Port:      udpPortFlag,
+				Interface: multicastIfaceFlag,
+				TTL:       multicastTTLFlag,
+			}
+		}
+		dest.Discard = discard
+		underwayFiles, err := expandPaths(underwayFileFlag)
+		if err != nil {
+			logger.Fatalf("error: --underway: %v\n", err)
+		}
+		tee := udpTeeFlag
+		if discard {
+			tee = ""
+		}
+		typeThrottles, err := parseThrottleTypes(throttleTypeFlag)
+		if err != nil {
+			logger.Fatalf("error: --throttle-type: %v\n", err)
+		}
+		underwayData, err := feeds.NewUnderway(underwayFiles, dest, underwayParserFlag, underwayThrottleFlag,
+			feeds.UnderwayOptions{
+				Options:       feedOpts,
+				FixChecksum:   fixChecksumFlag,
+				CoalesceAll:   coalesceAllFlag,
+				NoCoalesce:    noCoalesceFlag,
+				Subsecond:     subsecondFlag,
+				SplitLines:    udpSplitFlag,
+				SplitDelay:    udpSplitDelayFlag,
+				MaxPayload:    maxUDPPayloadFlag,
+				Retries:       udpRetriesFlag,
+				Tee:           tee,
+				Sample:        underwaySampleFlag,
+				TypeThrottles: typeThrottles,
+			})
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if len(underwayData.Warnings()) > 0 {
+			for _, w := range underwayData.Warnings() {
+				logger.Warnf("%v", w)
+			}
+			logger.Detailf("-------------------------------------------------------\n")
+		}
+		logger.Detailf("\n")
+		emitters = append(emitters, underwayData)
+	}
+
+	if instrumentLogFlag != "" {
+		// SeaFlow instrument log feed
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("Reading SeaFlow log data\n")
+		logger.Detailf("-------------------------------------------------------\n")
+		seaflogData, err := feeds.NewSeaLog(instrumentLogFlag, outDirFlag, feeds.SeaLogOptions{
+			Options:  feedOpts,
+			Fsync:    fsyncFlag,
+			Verbatim: verbatimLogFlag,
+			Events:   logEventsFlag,
+		})
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if len(seaflogData.Warnings()) > 0 {
+			for _, w := range seaflogData.Warnings() {
+				logger.Warnf("%v", w)
+			}
+			logger.Detailf("-------------------------------------------------------\n")
+		}
+		if logEventsFlag != nil {
+			logger.Printf("seaflowlog: skipped %d events not in --log-events\n", seaflogData.Skipped())
+		}
+		logger.Detailf("\n")
+		emitters = append(emitters, seaflogData)
+	}
+
+	for _, spec := range genericFlag {
+		// Generic timestamped text feeds
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("Reading generic data %v\n", spec)
+		logger.Detailf("-------------------------------------------------------\n")
+		file, col, layout, outPath, err := parseGenericSpec(spec)
+		if err != nil {
+			logger.Fatalf("error: --generic: %v\n", err)
+		}
+		if !filepath.IsAbs(outPath) {
+			outPath = filepath.Join(outDirFlag, outPath)
+		}
+		genericData, err := feeds.NewGeneric(file, col, layout, outPath, feedOpts)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if len(genericData.Warnings()) > 0 {
+			for _, w := range genericData.Warnings() {
+				logger.Warnf("%v", w)
+			}
+			logger.Detailf("-------------------------------------------------------\n")
+		}
+		logger.Detailf("\n")
+		emitters = append(emitters, genericData)
+	}
+
+	for _, spec := range globFeedFlag {
+		// Timestamped files matched by a glob
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("Reading glob feed %v\n", spec)
+		logger.Detailf("-------------------------------------------------------\n")
+		pattern, outPath, layout, re, err := parseGlobFeedSpec(spec)
+		if err != nil {
+			logger.Fatalf("error: --glob-feed: %v\n", err)
+		}
+		if !filepath.IsAbs(outPath) {
+			outPath = filepath.Join(outDirFlag, outPath)
+		}
+		globData, err := feeds.NewGlobFile(pattern, re, layout, outPath, feedOpts)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if len(globData.Warnings()) > 0 {
+			for _, w := range globData.Warnings() {
+				logger.Warnf("%v", w)
+			}
+			logger.Detailf("-------------------------------------------------------\n")
+		}
+		logger.Detailf("\n")
+		emitters = append(emitters, globData)
+	}
+
+	for _, e := range emitters {
+		if d, ok := e.(duplicateCounter); ok && dedupeFlag {
+			logger.Printf("%v: dropped %d duplicate records\n", e.Name(), d.Duplicates())
+		}
+	}
+
+	if warningsFileFlag != "" {
+		if err := writeWarnings(warningsFileFlag, emitters); err != nil {
+			logger.Fatalf("error: --warnings-file: %v\n", err)
+		}
+	}
+	logger.Detailf("--warnings-file = %v\n", warningsFileFlag)
+	return emitters
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// level is a log record severity. Records above the logger's level are
+// dropped.
+type level int
+
+const (
+	levelError level = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+func (lvl level) String() string {
+	return levelNames[lvl]
+}
+
+func parseLevel(name string) (level, error) {
+	for i, n := range levelNames {
+		if n == name {
+			return level(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown level %q, choose from %s", name, strings.Join(levelNames, ", "))
+}
+
+// fields are structured values attached to a log record.
+type fields map[string]interface{}
+
+// replayLogger writes human-readable log lines or, in JSON format, one JSON
+// object per record with a timestamp, level, message, and any fields.
+type replayLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	json  bool
+	level level
+	quiet bool // drop Detailf messages
+}
+
+func newReplayLogger(out io.Writer) *replayLogger {
+	return &replayLogger{out: out, level: levelInfo}
+}
+
+// setFormat selects "text" or "json" output.
+func (l *replayLogger) setFormat(format string) error {
+	switch format {
+	case "text":
+		l.json = false
+	case "json":
+		l.json = true
+	default:
+		return fmt.Errorf("unknown log format %q, choose text or json", format)
+	}
+	return nil
+}
+
+// setLevel sets the most verbose level that's logged by name.
+func (l *replayLogger) setLevel(name string) (err error) {
+	l.level, err = parseLevel(name)
+	return err
+}
+
+// Printf logs a formatted info message.
+func (l *replayLogger) Printf(format string, v ...interface{}) {
+	l.Log(levelInfo, fmt.Sprintf(format, v...), nil)
+}
+
+// Detailf logs a formatted info message that isn't needed to follow a replay,
+// such as startup banners. It's dropped with --quiet.
+func (l *replayLogger) Detailf(format string, v ...interface{}) {
+	if l.quiet {
+		return
+	}
+	l.Log(levelInfo, fmt.Sprintf(format, v...), nil)
+}
+
+// Debugf logs a formatted debug message.
+func (l *replayLogger) Debugf(format string, v ...interface{}) {
+	l.Log(levelDebug, fmt.Sprintf(format, v...), nil)
+}
+
+// Warnf logs a formatted warning message.
+func (l *replayLogger) Warnf(format string, v ...interface{}) {
+	l.Log(levelWarn, fmt.Sprintf(format, v...), nil)
+}
+
+// Errorf logs a formatted error message.
+func (l *replayLogger) Errorf(format string, v ...interface{}) {
+	l.Log(levelError, fmt.Sprintf(format, v...), nil)
+}
+
+// Fatalf logs a formatted error message regardless of level and exits.
+func (l *replayLogger) Fatalf(format string, v ...interface{}) {
+	l.write("fatal", fmt.Sprintf(format, v...), nil)
+	os.Exit(1)
+}
+
+// Log writes msg at lvl. In text format msg is written as a line and f is
+// dropped, so msg should already describe anything important in f.
+func (l *replayLogger) Log(lvl level, msg string, f fields) {
+	if lvl > l.level {
+		return
+	}
+	l.write(lvl.String(), msg, f)
+}
+
+func (l *replayLogger) write(levelName string, msg string, f fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.json {
+		if !strings.HasSuffix(msg, "\n") {
+			msg += "\n"
+		}
+		io.WriteString(l.out, msg)
+		return
+	}
+	msg = strings.TrimSpace(msg)
+	if strings.Trim(msg, "-") == "" {
+		// Section banners and spacing only make sense in text logs
+		return
+	}
+	rec := fields{}
+	for k, v := range f {
+		rec[k] = v
+	}
+	rec["time"] = time.Now().UTC()
+	rec["level"] = levelName
+	rec["msg"] = msg
+	b, err := json.Marshal(rec)
+	if err != nil {
+		b, _ = json.Marshal(fields{"time": rec["time"], "level": "error", "msg": err.Error()})
+	}
+	l.out.Write(append(b, '\n'))
+}
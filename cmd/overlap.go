@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// minFeedOverlap is the fraction of the shorter of two feeds' time ranges
+// that the other must cover for them to look like the same cruise.
+const minFeedOverlap = 0.5
+
+// checkOverlap logs each feed's time range and warns about every pair of
+// feeds whose ranges don't substantially overlap, which usually means a flag
+// points at data from another cruise. It returns the number of warnings.
+func checkOverlap(es []feeds.Emitter) (warnings int) {
+	var ranged []feeds.Emitter
+	var stats []feeds.FeedStats // parallel to ranged
+	for _, e := range es {
+		st := e.Stats()
+		if st.Len == 0 {
+			continue
+		}
+		logger.Detailf("%v covers %v to %v\n", e.Name(), formatTime(st.Earliest), formatTime(st.Latest))
+		ranged = append(ranged, e)
+		stats = append(stats, st)
+	}
+	for i, a := range ranged {
+		for j, b := range ranged[i+1:] {
+			as, bs := stats[i], stats[i+1+j]
+			start, end := as.Earliest, as.Latest
+			if bs.Earliest.After(start) {
+				start = bs.Earliest
+			}
+			if bs.Latest.Before(end) {
+				end = bs.Latest
+			}
+			overlap := end.Sub(start)
+			if overlap < 0 {
+				logger.Warnf("%v (%v to %v) and %v (%v to %v) don't overlap, are they from the same cruise?\n",
+					a.Name(), formatTime(as.Earliest), formatTime(as.Latest),
+					b.Name(), formatTime(bs.Earliest), formatTime(bs.Latest))
+				warnings++
+				continue
+			}
+			shorter := as.Latest.Sub(as.Earliest)
+			if span := bs.Latest.Sub(bs.Earliest); span < shorter {
+				shorter = span
+			}
+			if shorter > 0 && float64(overlap)/float64(shorter) < minFeedOverlap {
+				logger.Warnf("%v and %v overlap for only %v of %v, are they from the same cruise?\n",
+					a.Name(), b.Name(), overlap, shorter)
+				warnings++
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// bundleManifestName is the bundle member holding its manifest.
+const bundleManifestName = "manifest.json"
+
+// bundleFlags are the flags that change how inputs are read, carried in a
+// bundle so it replays the same records elsewhere.
+var bundleFlags = []string{"tz", "underway-parser", "throttle", "throttle-type", "log-events", "dedupe"}
+
+// bundleManifest describes a replay bundle. Paths are relative to the bundle
+// root, with slashes.
+type bundleManifest struct {
+	Version int                 `json:"version"`
+	Created string              `json:"created"`
+	Inputs  []bundleInput       `json:"inputs"`
+	Flags   map[string][]string `json:"flags,omitempty"`
+	Feeds   []bundleFeed        `json:"feeds"`
+	Files   []bundleFile        `json:"files"`
+}
+
+// bundleInput is an input flag value. Its path is the value up to the first
+// colon, so unpack can move it under the directory it unpacks to.
+type bundleInput struct {
+	Flag  string `json:"flag"`
+	Value string `json:"value"`
+}
+
+// bundleFeed is a feed as read when the bundle was packed.
+type bundleFeed struct {
+	Name     string `json:"name"`
+	Records  int    `json:"records"`
+	Earliest string `json:"earliest"`
+	Latest   string `json:"latest"`
+}
+
+// bundleFile is a bundle member with its size and SHA-256 checksum.
+type bundleFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	src    string
+}
+
+// packCmd archives the replay inputs and their manifest into one bundle
+var packCmd = &cobra.Command{
+	Use:   "pack bundle.tar.gz",
+	Short: "Pack the replay inputs into a bundle for replaying elsewhere",
+	Long: `Pack reads every feed given by the replay flags, as validate does, then
+writes their input files to a gzipped tar bundle with a manifest of each feed's
+records and time range, each file's checksum, and the flags that affect how
+inputs are read. Unpack the bundle with cruisereplay unpack to replay it.`,
+	Args: cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogger()
+		for _, name := range []string{"shift-time", "filter-from", "filter-to"} {
+			if cmd.Flags().Changed(name) {
+				logger.Fatalf("error: pack keeps whole inputs, give --%s when replaying the bundle\n", name)
+			}
+		}
+		if instrumentLogFlag == "-" {
+			logger.Fatalf("error: pack can't read --seaflowlog from stdin\n")
+		}
+		feedOpts := parseFeedOptions()
+		emitters := loadEmitters(feedOpts, true)
+		if err := checkNames(emitters); err != nil {
+			logger.Fatalf("error: %v\n", err)
+		}
+		if len(emitters) == 0 {
+			logger.Fatalf("error: no feeds to pack\n")
+		}
+
+		m, err := newBundleManifest(cmd.Flags())
+		if err != nil {
+			logger.Fatalf("error: pack: %v\n", err)
+		}
+		for _, e := range emitters {
+			st := e.Stats()
+			m.Feeds = append(m.Feeds, bundleFeed{Name: e.Name(), Records: st.Len, Earliest: formatTime(st.Earliest), Latest: formatTime(st.Latest)})
+			e.Close()
+		}
+		size, err := writeBundle(args[0], m)
+		if err != nil {
+			logger.Fatalf("error: pack: %v\n", err)
+		}
+		logger.Printf("packed %d files of %d feeds into %s, %d bytes\n", len(m.Files), len(m.Feeds), args[0], size)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(packCmd)
+}
+
+// newBundleManifest lists the files of every input flag with where they go in
+// the bundle, the input flags rewritten to those paths, and the reading flags
+// in bundleFlags that aren't at their defaults.
+func newBundleManifest(flags *pflag.FlagSet) (m bundleManifest, err error) {
+	m = bundleManifest{Version: 1, Created: time.Now().UTC().Format(time.RFC3339)}
+	seen := map[string]string{}
+	add := func(src, dst string) error {
+		if prev, ok := seen[dst]; ok {
+			return fmt.Errorf("%s and %s would both be packed as %s", prev, src, dst)
+		}
+		seen[dst] = src
+		m.Files = append(m.Files, bundleFile{Path: dst, src: src})
+		return nil
+	}
+	addTree := func(dir, prefix string, files []string) error {
+		for _, f := range files {
+			rel, err := filepath.Rel(dir, f)
+			if err != nil {
+				return err
+			}
+			if err := add(f, path.Join(prefix, filepath.ToSlash(rel))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if evtDirFlag != "" {
+		if feeds.IsTarArchive(evtDirFlag) {
+			dst := "evt/" + filepath.Base(evtDirFlag)
+			if err = add(evtDirFlag, dst); err != nil {
+				return m, err
+			}
+			m.Inputs = append(m.Inputs, bundleInput{"evt", dst})
+		} else {
+			evtFiles, err := feeds.FindEVTFiles(evtDirFlag)
+			if err != nil {
+				return m, err
+			}
+			sflFiles, err := feeds.FindSFLFiles(evtDirFlag)
+			if err != nil {
+				return m, err
+			}
+			if err = addTree(evtDirFlag, "evt", append(evtFiles, sflFiles...)); err != nil {
+				return m, err
+			}
+			m.Inputs = append(m.Inputs, bundleInput{"evt", "evt"})
+		}
+	}
+	if oppDirFlag != "" {
+		oppFiles, err := feeds.FindOPPFiles(oppDirFlag)
+		if err != nil {
+			return m, err
+		}
+		if err = addTree(oppDirFlag, "opp", oppFiles); err != nil {
+			return m, err
+		}
+		m.Inputs = append(m.Inputs, bundleInput{"opp", "opp"})
+	}
+	if underwayFileFlag != "" {
+		underwayFiles, err := expandPaths(underwayFileFlag)
+		if err != nil {
+			return m, err
+		}
+		// Keep the order files were given in, which is the order they're parsed
+		for i, f := range underwayFiles {
+			dst := fmt.Sprintf("underway/%d/%s", i+1, filepath.Base(f))
+			if err = add(f, dst); err != nil {
+				return m, err
+			}
+			m.Inputs = append(m.Inputs, bundleInput{"underway", dst})
+		}
+	}
+	if instrumentLogFlag != "" {
+		dst := "seaflowlog/" + filepath.Base(instrumentLogFlag)
+		if err = add(instrumentLogFlag, dst); err != nil {
+			return m, err
+		}
+		m.Inputs = append(m.Inputs, bundleInput{"seaflowlog", dst})
+	}
+	for i, spec := range genericFlag {
+		file, _, _, _, err := parseGenericSpec(spec)
+		if err != nil {
+			return m, err
+		}
+		dst := fmt.Sprintf("generic/%d/%s", i+1, filepath.Base(file))
+		if err = add(file, dst); err != nil {
+			return m, err
+		}
+		m.Inputs = append(m.Inputs, bundleInput{"generic", dst + spec[len(file):]})
+	}
+	for i, spec := range globFeedFlag {
+		pattern, _, _, _, err := parseGlobFeedSpec(spec)
+		if err != nil {
+			return m, err
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return m, err
+		}
+		dir := fmt.Sprintf("glob/%d", i+1)
+		for _, f := range files {
+			if fi, err := os.Stat(f); err != nil || fi.IsDir() {
+				continue
+			}
+			if err = add(f, dir+"/"+filepath.Base(f)); err != nil {
+				return m, err
+			}
+		}
+		m.Inputs = append(m.Inputs, bundleInput{"glob-feed", dir + "/*" + spec[len(pattern):]})
+	}
+
+	for _, name := range bundleFlags {
+		f := flags.Lookup(name)
+		if f == nil || f.Value.String() == f.DefValue {
+			continue
+		}
+		if m.Flags == nil {
+			m.Flags = map[string][]string{}
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			m.Flags[name] = sv.GetSlice()
+		} else {
+			m.Flags[name] = []string{f.Value.String()}
+		}
+	}
+	return m, nil
+}
+
+// writeBundle writes the files of m and then m itself to a gzipped tar at
+// outPath, filling in each file's size and checksum. The bundle only appears
+// at outPath once it's complete. It returns the bundle's size.
+func writeBundle(outPath string, m bundleManifest) (size int64, err error) {
+	f, err := os.CreateTemp(filepath.Dir(outPath), "."+filepath.Base(outPath)+".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+
+	for i := range m.Files {
+		if err = addBundleFile(tw, &m.Files[i]); err != nil {
+			return 0, err
+		}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	b = append(b, '\n')
+	hdr := &tar.Header{Name: bundleManifestName, Mode: 0644, Size: int64(len(b)), ModTime: time.Now()}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	if _, err = tw.Write(b); err != nil {
+		return 0, err
+	}
+
+	if err = tw.Close(); err != nil {
+		return 0, err
+	}
+	if err = zw.Close(); err != nil {
+		return 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(tmpPath, outPath); err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// addBundleFile copies bf's source file into tw, recording its size and
+// checksum in bf.
+func addBundleFile(tw *tar.Writer, bf *bundleFile) error {
+	src, err := os.Open(bf.src)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: bf.Path, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("%s: %v", bf.src, err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tw, h), src)
+	if err != nil {
+		return fmt.Errorf("%s: %v", bf.src, err)
+	}
+	bf.Size = n
+	bf.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
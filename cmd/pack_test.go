@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+	"github.com/spf13/pflag"
+)
+
+// writeTestFile writes data to dir/name, creating any parent directories, and
+// returns its path.
+func writeTestFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// gzipString returns s gzipped.
+func gzipString(s string) string {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.String()
+}
+
+// setInputFlags sets the input flags for the rest of the test, restoring them
+// and --out after it.
+func setInputFlags(t *testing.T, evt, underway string, generic, globFeed []string) {
+	t.Helper()
+	origEvt, origOpp, origUnderway, origLog := evtDirFlag, oppDirFlag, underwayFileFlag, instrumentLogFlag
+	origGeneric, origGlob, origOut := genericFlag, globFeedFlag, outDirFlag
+	t.Cleanup(func() {
+		evtDirFlag, oppDirFlag, underwayFileFlag, instrumentLogFlag = origEvt, origOpp, origUnderway, origLog
+		genericFlag, globFeedFlag, outDirFlag = origGeneric, origGlob, origOut
+	})
+	evtDirFlag, oppDirFlag, underwayFileFlag, instrumentLogFlag = evt, "", underway, ""
+	genericFlag, globFeedFlag = generic, globFeed
+}
+
+// bundleFixture writes a small cruise of EVT, SFL, underway, generic and glob
+// feed inputs under dir and sets the input flags to read it. The two underway
+// files share a base name so the bundle must keep them apart.
+func bundleFixture(t *testing.T, dir string) {
+	t.Helper()
+	evt := filepath.Join(dir, "cruise", "evt")
+	writeTestFile(t, evt, "2021_001/2021-01-01T00-00-00+00-00", "evt 0")
+	writeTestFile(t, evt, "2021_001/2021-01-01T00-03-00+00-00.gz", gzipString("evt 3"))
+	writeTestFile(t, evt, "2021_001/2021-01-01T00-00-00+00-00.sfl",
+		"DATE\tFILE_DURATION\tLAT\tLON\tCONDUCTIVITY\tSALINITY\tOCEAN_TEMP\tPAR\tBULK_RED\tSTREAM_PRESSURE\tEVENT_RATE\n"+
+			"2021-01-01T00:00:00+00:00\t180\t21.3\t-157.8\t5.1\t34.8\t25.2\t0\t0.1\t12.1\t1000\n"+
+			"2021-01-01T00:03:00+00:00\t180\t21.3\t-157.8\t5.1\t34.8\t25.2\t0\t0.1\t12.1\t1000\n")
+	nav1 := writeTestFile(t, dir, "cruise/underway/day1/nav.txt",
+		"2021 001 00 00 01 000 flor 78.000000\n2021 001 00 00 02 000 uthsl 19.968599 0.040550 0.217500 27.397800\n")
+	nav2 := writeTestFile(t, dir, "cruise/underway/day2/nav.txt", "2021 002 00 00 01 000 flor 79.000000\n")
+	met := writeTestFile(t, dir, "cruise/met.tsv",
+		"time\tpar\n2021-01-01T00:00:00Z\t1.0\n2021-01-01T00:01:00Z\t2.0\n")
+	writeTestFile(t, dir, "cruise/images/img-2021-01-01T00-00-00.png", "png 0")
+	writeTestFile(t, dir, "cruise/images/img-2021-01-01T00-02-00.png", "png 2")
+	images := filepath.Join(dir, "cruise", "images", "*.png")
+
+	setInputFlags(t, evt, nav1+","+nav2,
+		[]string{met + ":0:RFC3339:met.tsv"},
+		[]string{images + `:images:2006-01-02T15-04-05:\d{4}-\d\d-\d\dT\d\d-\d\d-\d\d`})
+}
+
+// replayBundleConfig sets the input flags from an unpacked bundle's config,
+// parsing its values as the replay command line would.
+func replayBundleConfig(t *testing.T, configPath string) {
+	t.Helper()
+	setInputFlags(t, "", "", nil, nil)
+	values, err := readConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	fs.StringVar(&evtDirFlag, "evt", "", "")
+	fs.StringVar(&underwayFileFlag, "underway", "", "")
+	fs.StringArrayVar(&genericFlag, "generic", nil, "")
+	fs.StringArrayVar(&globFeedFlag, "glob-feed", nil, "")
+	for _, kv := range values {
+		for _, v := range kv.values {
+			if err := fs.Set(kv.name, v); err != nil {
+				t.Fatalf("%s:%d: %v", configPath, kv.line, err)
+			}
+		}
+	}
+}
+
+// replayedFeed is what a feed emits over a full replay.
+type replayedFeed struct {
+	name  string
+	stats feeds.FeedStats
+}
+
+// replayInputs loads the feeds of the input flags and emits every record to
+// out, returning each feed and the files written.
+func replayInputs(t *testing.T, out string) (replayed []replayedFeed, files map[string]string) {
+	t.Helper()
+	outDirFlag = out
+	for _, e := range loadEmitters(feeds.Options{}, true) {
+		for e.Next() {
+			if err := e.Emit(); err != nil {
+				t.Fatalf("%s: %v", e.Name(), err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("%s: %v", e.Name(), err)
+		}
+		replayed = append(replayed, replayedFeed{e.Name(), e.Stats()})
+	}
+	files = map[string]string{}
+	err := filepath.Walk(out, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(out, path)
+		files[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replayed, files
+}
+
+func TestPackUnpackRoundTrip(t *testing.T) {
+	testLogger(t)
+	tmp := t.TempDir()
+	bundleFixture(t, tmp)
+	m, err := newBundleManifest(pflag.NewFlagSet("pack", pflag.ContinueOnError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInputs := []bundleInput{
+		{"evt", "evt"},
+		{"underway", "underway/1/nav.txt"},
+		{"underway", "underway/2/nav.txt"},
+		{"generic", "generic/1/met.tsv:0:RFC3339:met.tsv"},
+		{"glob-feed", `glob/1/*:images:2006-01-02T15-04-05:\d{4}-\d\d-\d\dT\d\d-\d\d-\d\d`},
+	}
+	if !reflect.DeepEqual(m.Inputs, wantInputs) {
+		t.Errorf("inputs = %v, want %v", m.Inputs, wantInputs)
+	}
+	var members []string
+	for _, f := range m.Files {
+		members = append(members, f.Path)
+	}
+	wantMembers := []string{
+		"evt/2021_001/2021-01-01T00-00-00+00-00",
+		"evt/2021_001/2021-01-01T00-03-00+00-00.gz",
+		"evt/2021_001/2021-01-01T00-00-00+00-00.sfl",
+		"underway/1/nav.txt",
+		"underway/2/nav.txt",
+		"generic/1/met.tsv",
+		"glob/1/img-2021-01-01T00-00-00.png",
+		"glob/1/img-2021-01-01T00-02-00.png",
+	}
+	if !reflect.DeepEqual(members, wantMembers) {
+		t.Errorf("members = %q, want %q", members, wantMembers)
+	}
+	if m.Flags != nil {
+		t.Errorf("flags at their defaults recorded as %v", m.Flags)
+	}
+	want, wantFiles := replayInputs(t, filepath.Join(tmp, "out-packed"))
+
+	bundle := filepath.Join(tmp, "cruise.tar.gz")
+	if _, err := writeBundle(bundle, m); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmp, "unpacked")
+	if _, err := unpackBundle(bundle, dir); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, bundleConfigName)
+	if err := writeBundleConfig(configPath, dir, m); err != nil {
+		t.Fatal(err)
+	}
+	replayBundleConfig(t, configPath)
+	if want := filepath.Join(dir, "evt"); evtDirFlag != want {
+		t.Errorf("--evt = %s, want %s", evtDirFlag, want)
+	}
+	if want := filepath.Join(dir, "underway", "1", "nav.txt") + "," + filepath.Join(dir, "underway", "2", "nav.txt"); underwayFileFlag != want {
+		t.Errorf("--underway = %s, want %s", underwayFileFlag, want)
+	}
+	if want := []string{filepath.Join(dir, "generic", "1", "met.tsv") + ":0:RFC3339:met.tsv"}; !reflect.DeepEqual(genericFlag, want) {
+		t.Errorf("--generic = %q, want %q", genericFlag, want)
+	}
+	if want := []string{filepath.Join(dir, "glob", "1", "*") + wantInputs[4].Value[len("glob/1/*"):]}; !reflect.DeepEqual(globFeedFlag, want) {
+		t.Errorf("--glob-feed = %q, want %q", globFeedFlag, want)
+	}
+
+	got, gotFiles := replayInputs(t, filepath.Join(tmp, "out-unpacked"))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unpacked bundle replayed %+v, want %+v", got, want)
+	}
+	if len(wantFiles) == 0 || !reflect.DeepEqual(gotFiles, wantFiles) {
+		t.Errorf("unpacked bundle wrote %q, want %q", gotFiles, wantFiles)
+	}
+}
+
+func TestBundleFlags(t *testing.T) {
+	var tz string
+	var logEvents []string
+	var throttle, other int64
+	flags := pflag.NewFlagSet("pack", pflag.ContinueOnError)
+	flags.StringVar(&tz, "tz", "", "")
+	flags.StringSliceVar(&logEvents, "log-events", nil, "")
+	flags.Int64Var(&throttle, "throttle", 0, "")
+	flags.Int64Var(&other, "not-a-bundle-flag", 0, "")
+	if err := flags.Parse([]string{"--tz", "Pacific/Honolulu", "--log-events", "start,stop", "--not-a-bundle-flag", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	setInputFlags(t, "", "", nil, nil)
+	m, err := newBundleManifest(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"tz": {"Pacific/Honolulu"}, "log-events": {"start", "stop"}}
+	if !reflect.DeepEqual(m.Flags, want) {
+		t.Fatalf("flags = %v, want %v", m.Flags, want)
+	}
+
+	configPath := filepath.Join(t.TempDir(), bundleConfigName)
+	if err := writeBundleConfig(configPath, filepath.Dir(configPath), m); err != nil {
+		t.Fatal(err)
+	}
+	values, err := readConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, kv := range values {
+		got[kv.name] = kv.values
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config sets %v, want %v", got, want)
+	}
+}
+
+// bundleMember is a file of a hand-written test bundle.
+type bundleMember struct {
+	name string
+	data string
+}
+
+// writeTestBundle writes members to a gzipped tar at path, in order.
+func writeTestBundle(t *testing.T, path string, members []bundleMember) {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(zw)
+	for _, mem := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: mem.name, Mode: 0644, Size: int64(len(mem.data))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(mem.data))
+	}
+	tw.Close()
+	zw.Close()
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestBundle returns the members of the bundle at path, in order.
+func readTestBundle(t *testing.T, path string) (members []bundleMember) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, bundleMember{hdr.Name, string(b)})
+	}
+}
+
+func TestUnpackRejectsMemberOutsideDir(t *testing.T) {
+	for _, name := range []string{"../evil", "evt/../../evil", "/tmp/evil", "evt//evil", "./evil"} {
+		t.Run(name, func(t *testing.T) {
+			tmp := t.TempDir()
+			bundle := filepath.Join(tmp, "bundle.tar.gz")
+			writeTestBundle(t, bundle, []bundleMember{{name, "evil"}, {bundleManifestName, `{"version": 1}`}})
+			dir := filepath.Join(tmp, "a", "unpacked")
+			_, err := unpackBundle(bundle, dir)
+			if err == nil || !strings.Contains(err.Error(), "bad member name") {
+				t.Fatalf("unpack of member %q = %v, want a bad member name error", name, err)
+			}
+			if files := listFiles(t, tmp); !reflect.DeepEqual(files, []string{"bundle.tar.gz"}) {
+				t.Errorf("unpack wrote %q", files)
+			}
+		})
+	}
+}
+
+func TestUnpackRejectsCorruptBundle(t *testing.T) {
+	tmp := t.TempDir()
+	met := writeTestFile(t, tmp, "met.tsv", "time\tpar\n2021-01-01T00:00:00Z\t1.0\n")
+	setInputFlags(t, "", "", []string{met + ":0:RFC3339:met.tsv"}, nil)
+	m, err := newBundleManifest(pflag.NewFlagSet("pack", pflag.ContinueOnError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(tmp, "bundle.tar.gz")
+	if _, err := writeBundle(bundle, m); err != nil {
+		t.Fatal(err)
+	}
+	members := readTestBundle(t, bundle)
+	if len(members) != 2 || members[0].name != "generic/1/met.tsv" {
+		t.Fatalf("bundle members = %v, want met.tsv and the manifest", members)
+	}
+
+	tests := []struct {
+		name    string
+		member  func(data string) (string, bool) // the changed data, false to drop it
+		wantErr string
+	}{
+		{"same size", func(data string) (string, bool) { return strings.Replace(data, "1.0", "2.0", 1), true }, "doesn't match its checksum"},
+		{"truncated", func(data string) (string, bool) { return data[:len(data)/2], true }, "doesn't match its checksum"},
+		{"missing", func(data string) (string, bool) { return "", false }, "missing generic/1/met.tsv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var corrupt []bundleMember
+			if data, ok := tt.member(members[0].data); ok {
+				corrupt = append(corrupt, bundleMember{members[0].name, data})
+			}
+			corrupt = append(corrupt, members[1])
+			path := filepath.Join(t.TempDir(), "corrupt.tar.gz")
+			writeTestBundle(t, path, corrupt)
+			_, err := unpackBundle(path, filepath.Join(t.TempDir(), "unpacked"))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("unpack = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteBundleConfigRejectsBadInputPath(t *testing.T) {
+	for _, value := range []string{"../evt", "/evt", "generic/../../met.tsv:0:RFC3339:met.tsv"} {
+		m := bundleManifest{Inputs: []bundleInput{{"evt", value}}}
+		path := filepath.Join(t.TempDir(), bundleConfigName)
+		if err := writeBundleConfig(path, filepath.Dir(path), m); err == nil || !strings.Contains(err.Error(), "bad input path") {
+			t.Errorf("config for input %q = %v, want a bad input path error", value, err)
+		}
+	}
+}
+
+// listFiles returns the paths of every file under dir, relative to it.
+func listFiles(t *testing.T, dir string) (files []string) {
+	t.Helper()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+	"github.com/spf13/cobra"
+)
+
+// parsersCmd lists the underway parsers that --underway-parser accepts
+var parsersCmd = &cobra.Command{
+	Use:   "parsers",
+	Short: "List underway feed parsers",
+	Long: `Parsers prints the name of every underway parser that --underway-parser
+accepts, one per line. The default parser is marked.`,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		def := rootCmd.PersistentFlags().Lookup("underway-parser").DefValue
+		for _, name := range feeds.ParserNames() {
+			if name == def {
+				fmt.Printf("%s (default)\n", name)
+			} else {
+				fmt.Println(name)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(parsersCmd)
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// replayPause is the pause state shared by every emitter of a replay. While
+// paused, emitters hold before arming their next timer, and on resume every
+// later record is scheduled later by the length of the pause, as if the replay
+// clock had stopped. A nil *replayPause is never paused.
+type replayPause struct {
+	mu      sync.Mutex
+	since   time.Time     // start of the current pause, zero if running
+	total   time.Duration // summed length of finished pauses
+	paused  chan struct{} // closed when the current pause starts
+	resumed chan struct{} // closed when the current pause ends
+}
+
+func newReplayPause() *replayPause {
+	return &replayPause{paused: make(chan struct{}), resumed: make(chan struct{})}
+}
+
+// toggle pauses a running replay or resumes a paused one. It reports whether
+// the replay is now paused and, on resume, how long the pause lasted.
+func (p *replayPause) toggle() (paused bool, length time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.since.IsZero() {
+		p.since = time.Now()
+		close(p.paused)
+		p.resumed = make(chan struct{})
+		return true, 0
+	}
+	length = time.Since(p.since)
+	p.total += length
+	p.since = time.Time{}
+	close(p.resumed)
+	p.paused = make(chan struct{})
+	return false, length
+}
+
+// offset returns the summed length of every finished pause.
+func (p *replayPause) offset() time.Duration {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// pausing returns a channel that's closed when the replay is paused, already
+// closed if it's paused now.
+func (p *replayPause) pausing() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// wait blocks while the replay is paused. It returns ctx's error if ctx is
+// cancelled first.
+func (p *replayPause) wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	if p.since.IsZero() {
+		p.mu.Unlock()
+		return nil
+	}
+	resumed := p.resumed
+	p.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+// togglePauseOnSignal pauses or resumes the replay on each pauseSignal until
+// ctx is cancelled, logging each transition and moving the projected finish
+// back by the length of each pause. It does nothing on platforms without
+// a pauseSignal.
+func togglePauseOnSignal(ctx context.Context, p *replayPause, eta *replayETA) {
+	if pauseSignal == nil {
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, pauseSignal)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			paused, length := p.toggle()
+			if paused {
+				logger.Log(levelInfo, "replay paused\n", fields{"event": "paused"})
+				continue
+			}
+			length = length.Round(time.Millisecond)
+			logger.Log(levelInfo, fmt.Sprintf("replay resumed after %v\n", length),
+				fields{"event": "resumed", "paused": length.String()})
+			if finish := eta.get(); !finish.IsZero() {
+				eta.set(finish.Add(length))
+				logETA(eta)
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package cmd
+
+import "os"
+
+// pauseSignal toggles pausing the replay. There's no SIGUSR1 here, so
+// replays can't be paused.
+var pauseSignal os.Signal
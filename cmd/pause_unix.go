@@ -0,0 +1,12 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// pauseSignal toggles pausing the replay.
+var pauseSignal os.Signal = syscall.SIGUSR1
@@ -22,9 +22,17 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/armbrustlab/cruisereplay/feeds"
@@ -33,19 +41,87 @@ import (
 
 var Version string = "v0.1.2"
 
-var logger *log.Logger
+var logger *replayLogger
 
 // flag variables
 var (
 	evtDirFlag           string
+	oppDirFlag           string
 	underwayFileFlag     string
+	underwayParserFlag   string
 	instrumentLogFlag    string
 	startFlag            string
+	endFlag              string
 	warpFlag             float64
+	warpScheduleFlag     string
+	finishByFlag         string
+	evtWarpFlag          float64
+	sflWarpFlag          float64
+	oppWarpFlag          float64
+	underwayWarpFlag     float64
+	seaflowlogWarpFlag   float64
+	genericWarpFlag      float64
 	outDirFlag           string
 	udpPortFlag          uint
 	udpHostFlag          string
+	protoFlag            string
+	multicastIfaceFlag   string
+	multicastTTLFlag     int
 	underwayThrottleFlag int64
+	throttleTypeFlag     []string
+	loopFlag             int
+	seekFlag             bool
+	compressSflFlag      bool
+	sflUDPFlag           string
+	sflUDPOnlyFlag       bool
+	fsyncFlag            bool
+	verbatimLogFlag      bool
+	logEventsFlag        []string
+	progressFlag         time.Duration
+	dryRunFlag           bool
+	genericFlag          []string
+	globFeedFlag         []string
+	underwayOutFlag      string
+	fixChecksumFlag      bool
+	coalesceAllFlag      bool
+	noCoalesceFlag       bool
+	subsecondFlag        bool
+	udpSplitFlag         bool
+	udpSplitDelayFlag    time.Duration
+	maxUDPPayloadFlag    int
+	udpRetriesFlag       int
+	udpTeeFlag           string
+	udpHeartbeatFlag     string
+	verbosityFlag        string
+	quietFlag            bool
+	filterFromFlag       string
+	filterToFlag         string
+	shiftTimeFlag        time.Duration
+	sinceNowFlag         bool
+	tzFlag               string
+	pathTemplateFlag     string
+	dirModeFlag          string
+	fileModeFlag         string
+	maxCopiesFlag        int
+	copyBufferFlag       int
+	evtLinkFlag          string
+	evtEmitFlag          bool
+	evtMtimeSkewFlag     time.Duration
+	manifestFlag         bool
+	catchUpFlag          bool
+	requireNonemptyFlag  bool
+	maxWarningsFlag      int
+	dedupeFlag           bool
+	evtSampleFlag        int
+	underwaySampleFlag   int
+	warningsFileFlag     string
+	reportGapsFlag       time.Duration
+	lagWarnFlag          time.Duration
+	jitterFlag           time.Duration
+	startInFlag          time.Duration
+	seedFlag             int64
+	statusAddrFlag       string
+	logFormatFlag        string
 	versionFlag          bool
 )
 
@@ -58,6 +134,7 @@ var rootCmd = &cobra.Command{
 Supported data feeds are:
   * SeaFlow EVT
   * SeaFlow SFL
+  * SeaFlow OPP
   # SeaFlow instrument log data
   * Kilo Moana underway data`,
 
@@ -69,112 +146,213 @@ Supported data feeds are:
 			return
 		}
 
-		logger.Printf("-------------------------------------------------------\n")
-		logger.Printf("CLI options\n")
-		logger.Printf("-------------------------------------------------------\n")
-		logger.Printf("--evt = %v\n", evtDirFlag)
-		logger.Printf("--underway = %v\n", underwayFileFlag)
-		logger.Printf("--seaflowlog = %v\n", instrumentLogFlag)
-		logger.Printf("--host = %v\n", udpHostFlag)
-		logger.Printf("--port = %v\n", udpPortFlag)
-		logger.Printf("--throttle = %vs\n", underwayThrottleFlag)
+		setupLogger()
+
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("CLI options\n")
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("--config = %v\n", configFlag)
+		logger.Detailf("--evt = %v\n", evtDirFlag)
+		logger.Detailf("--opp = %v\n", oppDirFlag)
+		logger.Detailf("--underway = %v\n", underwayFileFlag)
+		logger.Detailf("--underway-parser = %v\n", underwayParserFlag)
+		logger.Detailf("--seaflowlog = %v\n", instrumentLogFlag)
+		logger.Detailf("--generic = %v\n", genericFlag)
+		logger.Detailf("--glob-feed = %v\n", globFeedFlag)
+		logger.Detailf("--evt-link = %v\n", evtLinkFlag)
+		logger.Detailf("--evt-emit = %v\n", evtEmitFlag)
+		logger.Detailf("--evt-mtime-skew = %v\n", evtMtimeSkewFlag)
+		logger.Detailf("--evt-sample = %v\n", evtSampleFlag)
+		logger.Detailf("--underway-sample = %v\n", underwaySampleFlag)
+		logger.Detailf("--compress-sfl = %v\n", compressSflFlag)
+		logger.Detailf("--sfl-udp = %v\n", sflUDPFlag)
+		logger.Detailf("--sfl-udp-only = %v\n", sflUDPOnlyFlag)
+		logger.Detailf("--fsync = %v\n", fsyncFlag)
+		logger.Detailf("--verbatim-log = %v\n", verbatimLogFlag)
+		logger.Detailf("--log-events = %v\n", logEventsFlag)
+		logger.Detailf("--host = %v\n", udpHostFlag)
+		logger.Detailf("--port = %v\n", udpPortFlag)
+		logger.Detailf("--proto = %v\n", protoFlag)
+		logger.Detailf("--underway-out = %v\n", underwayOutFlag)
+		logger.Detailf("--fix-nmea-checksum = %v\n", fixChecksumFlag)
+		logger.Detailf("--coalesce-all = %v\n", coalesceAllFlag)
+		logger.Detailf("--no-coalesce = %v\n", noCoalesceFlag)
+		if noCoalesceFlag && coalesceAllFlag {
+			logger.Fatalf("error: --no-coalesce can't be used with --coalesce-all\n")
+		}
+		logger.Detailf("--underway-subsecond = %v\n", subsecondFlag)
+		logger.Detailf("--udp-split = %v\n", udpSplitFlag)
+		logger.Detailf("--udp-split-delay = %v\n", udpSplitDelayFlag)
+		logger.Detailf("--max-udp-payload = %v\n", maxUDPPayloadFlag)
+		logger.Detailf("--udp-retries = %v\n", udpRetriesFlag)
+		logger.Detailf("--udp-tee = %v\n", udpTeeFlag)
+		var heartbeatInterval time.Duration
+		var heartbeatPayload string
+		if udpHeartbeatFlag != "" {
+			var err error
+			if heartbeatInterval, heartbeatPayload, err = parseHeartbeatSpec(udpHeartbeatFlag); err != nil {
+				logger.Fatalf("error: --udp-heartbeat: %v\n", err)
+			}
+			if underwayFileFlag == "" {
+				logger.Fatalf("error: --udp-heartbeat requires --underway\n")
+			}
+		}
+		logger.Detailf("--udp-heartbeat = %v\n", udpHeartbeatFlag)
+		logger.Detailf("--multicast-interface = %v\n", multicastIfaceFlag)
+		logger.Detailf("--multicast-ttl = %v\n", multicastTTLFlag)
+		logger.Detailf("--throttle = %vs\n", underwayThrottleFlag)
+		logger.Detailf("--throttle-type = %v\n", throttleTypeFlag)
+		if len(throttleTypeFlag) > 0 && underwayFileFlag == "" {
+			logger.Fatalf("error: --throttle-type requires --underway\n")
+		}
+		logger.Detailf("--loop = %v\n", loopFlag)
+		logger.Detailf("--progress = %v\n", progressFlag)
+		logger.Detailf("--dry-run = %v\n", dryRunFlag)
+		logger.Detailf("--status-addr = %v\n", statusAddrFlag)
+		logger.Detailf("--log-format = %v\n", logFormatFlag)
+		logger.Detailf("--verbosity = %v\n", verbosityFlag)
+		logger.Detailf("--quiet = %v\n", quietFlag)
+		logger.Detailf("--lag-warn = %v\n", lagWarnFlag)
 		var cruiseStart time.Time
 		if startFlag != "" {
 			cruiseStart, err = time.Parse(time.RFC3339, startFlag)
 			if err != nil {
 				logger.Fatalf("error: --start: %v\n", err)
 			}
-			logger.Printf("--start = %v\n", cruiseStart)
+			logger.Detailf("--start = %v\n", cruiseStart)
 		} else {
-			logger.Printf("--start = ")
+			logger.Detailf("--start = ")
 		}
-		logger.Printf("-------------------------------------------------------\n")
-		logger.Printf("\n")
-
-		emitters := []feeds.Emitter{}
-
-		// EVT feed
-		if (evtDirFlag != "") {
-			logger.Printf("-------------------------------------------------------\n")
-			logger.Printf("Reading EVT data\n")
-			logger.Printf("-------------------------------------------------------\n")
-			evtFiles, err := feeds.FindEVTFiles(evtDirFlag)
+		var cruiseEnd time.Time
+		if endFlag != "" {
+			cruiseEnd, err = time.Parse(time.RFC3339, endFlag)
 			if err != nil {
-				logger.Fatalf("%v", err)
+				logger.Fatalf("error: --end: %v\n", err)
 			}
-			evtData, err := feeds.NewEvt(evtFiles, outDirFlag)
-			if err != nil {
-				logger.Fatalf("%v", err)
+			logger.Detailf("--end = %v\n", cruiseEnd)
+			if !cruiseStart.IsZero() && !cruiseEnd.After(cruiseStart) {
+				logger.Fatalf("error: --end must be after --start\n")
 			}
-			if len(evtData.Warnings()) > 0 {
-				for _, w := range evtData.Warnings() {
-					logger.Printf("%v", w)
-				}
-				logger.Printf("-------------------------------------------------------\n")
+		} else {
+			logger.Detailf("--end = ")
+		}
+		logger.Detailf("--warp = %v\n", warpFlag)
+		if !validWarp(warpFlag) {
+			logger.Fatalf("error: --warp must be positive and finite, got %v\n", warpFlag)
+		}
+		logger.Detailf("--warp-schedule = %v\n", warpScheduleFlag)
+		warps, err := parseWarpSchedule(warpScheduleFlag, warpFlag)
+		if err != nil {
+			logger.Fatalf("error: --warp-schedule: %v\n", err)
+		}
+		logger.Detailf("--evt-warp = %v\n", evtWarpFlag)
+		logger.Detailf("--sfl-warp = %v\n", sflWarpFlag)
+		logger.Detailf("--opp-warp = %v\n", oppWarpFlag)
+		logger.Detailf("--underway-warp = %v\n", underwayWarpFlag)
+		logger.Detailf("--seaflowlog-warp = %v\n", seaflowlogWarpFlag)
+		logger.Detailf("--generic-warp = %v\n", genericWarpFlag)
+		anyFeedWarp, err := checkFeedWarps()
+		if err != nil {
+			logger.Fatalf("error: %v\n", err)
+		}
+		var finishBy time.Time
+		if finishByFlag != "" {
+			if finishBy, err = time.Parse(time.RFC3339, finishByFlag); err != nil {
+				logger.Fatalf("error: --finish-by: %v\n", err)
 			}
-			logger.Printf("\n")
-			emitters = append(emitters, evtData)
-
-			// SFL feed
-			logger.Printf("-------------------------------------------------------\n")
-			logger.Printf("Reading SFL data\n")
-			logger.Printf("-------------------------------------------------------\n")
-			sflFiles, err := feeds.FindSFLFiles(evtDirFlag)
-			if err != nil {
-				logger.Fatalf("%v", err)
+			if cmd.Flags().Changed("warp") || warpScheduleFlag != "" {
+				logger.Fatalf("error: --finish-by sets the warp factor, it can't be used with --warp or --warp-schedule\n")
 			}
-			sflData, err := feeds.NewSfl(sflFiles, outDirFlag)
-			if err != nil {
-				logger.Fatalf("%v", err)
+			if loopFlag >= 0 {
+				logger.Fatalf("error: --finish-by can't be used with --loop\n")
 			}
-			if len(sflData.Warnings()) > 0 {
-				for _, w := range sflData.Warnings() {
-					logger.Printf("%v", w)
-				}
-				logger.Printf("-------------------------------------------------------\n")
+			if anyFeedWarp {
+				logger.Fatalf("error: --finish-by can't be used with per-feed warps like --evt-warp\n")
+			}
+		}
+		logger.Detailf("--finish-by = %v\n", finishByFlag)
+		logger.Detailf("--start-in = %v\n", startInFlag)
+		if startInFlag < 0 {
+			logger.Fatalf("error: --start-in must not be negative\n")
+		}
+		logger.Detailf("--jitter = %v\n", jitterFlag)
+		if jitterFlag < 0 {
+			logger.Fatalf("error: --jitter must not be negative\n")
+		}
+		if seedFlag == 0 {
+			seedFlag = time.Now().UnixNano()
+		}
+		logger.Detailf("--seed = %v\n", seedFlag)
+		logger.Detailf("--catch-up = %v\n", catchUpFlag)
+		logger.Detailf("--require-nonempty = %v\n", requireNonemptyFlag)
+		logger.Detailf("--report-gaps = %v\n", reportGapsFlag)
+		logger.Detailf("--seek = %v\n", seekFlag)
+		if seekFlag && cruiseStart.IsZero() {
+			logger.Fatalf("error: --seek requires --start\n")
+		}
+		feedOpts := parseFeedOptions()
+		logger.Detailf("--since-now = %v\n", sinceNowFlag)
+		if sinceNowFlag {
+			if shiftTimeFlag != 0 || startFlag != "" || endFlag != "" || filterFromFlag != "" || filterToFlag != "" {
+				logger.Fatalf("error: --since-now can't be used with --shift-time, --start, --end, --filter-from, or --filter-to\n")
 			}
-			logger.Printf("\n")
-			emitters = append(emitters, sflData)
+			feedOpts.Shift = sinceNowShift(feedOpts, startInFlag)
+			logger.Detailf("--since-now shift = %v\n", feedOpts.Shift)
 		}
+		logger.Detailf("--manifest = %v\n", manifestFlag)
+		logger.Detailf("-------------------------------------------------------\n")
+		logger.Detailf("\n")
 
-		if (underwayFileFlag != "") {
-			// Underway feed
-			logger.Printf("-------------------------------------------------------\n")
-			logger.Printf("Reading underway data\n")
-			logger.Printf("-------------------------------------------------------\n")
-			underwayData, err := feeds.NewUnderway(
-				underwayFileFlag, udpHostFlag, udpPortFlag, underwayThrottleFlag,
-			)
-			if err != nil {
-				logger.Fatalf("%v", err)
+		// Fail fast on an unwritable output directory rather than at the first emit
+		if !dryRunFlag {
+			var subdirs []string
+			if feedOpts.Paths != nil {
+				// Templated paths are only known per record
+				subdirs = append(subdirs, "")
+			}
+			if evtDirFlag != "" && feedOpts.Paths == nil {
+				subdirs = append(subdirs, filepath.Join("datafiles", "evt"))
+			}
+			if oppDirFlag != "" && feedOpts.Paths == nil {
+				subdirs = append(subdirs, filepath.Join("datafiles", "opp"))
 			}
-			if len(underwayData.Warnings()) > 0 {
-				for _, w := range underwayData.Warnings() {
-					logger.Printf("%v", w)
+			if instrumentLogFlag != "" {
+				subdirs = append(subdirs, "datafiles")
+			}
+			for _, spec := range globFeedFlag {
+				if _, outPath, _, _, err := parseGlobFeedSpec(spec); err == nil && !filepath.IsAbs(outPath) {
+					subdirs = append(subdirs, outPath)
 				}
-				logger.Printf("-------------------------------------------------------\n")
 			}
-			logger.Printf("\n")
-			emitters = append(emitters, underwayData)
+			if err := checkOutDir(outDirFlag, feedOpts.DirMode, subdirs...); err != nil {
+				logger.Fatalf("error: --outdir: %v\n", err)
+			}
+			if manifestFlag {
+				if feedOpts.Manifest, err = feeds.NewManifest(outDirFlag); err != nil {
+					logger.Fatalf("error: --manifest: %v\n", err)
+				}
+				// Deferred before the emitters' Close so it runs after them
+				defer feedOpts.Manifest.Close()
+			}
 		}
 
-		if (instrumentLogFlag != "") {
-			// SeaFlow instrument log feed
-			logger.Printf("-------------------------------------------------------\n")
-			logger.Printf("Reading SeaFlow log data\n")
-			logger.Printf("-------------------------------------------------------\n")
-			seaflogData, err := feeds.NewSeaLog(instrumentLogFlag, outDirFlag)
-			if err != nil {
-				logger.Fatalf("%v", err)
-			}
-			if len(seaflogData.Warnings()) > 0 {
-				for _, w := range seaflogData.Warnings() {
-					logger.Printf("%v", w)
+		// A dry run opens no sockets, devices, or tee files
+		emitters := loadEmitters(feedOpts, dryRunFlag)
+
+		if err := checkNames(emitters); err != nil {
+			logger.Fatalf("error: %v\n", err)
+		}
+		for _, e := range emitters {
+			if e.Len() == 0 {
+				if requireNonemptyFlag {
+					logger.Fatalf("error: %v feed has no records\n", e.Name())
 				}
-				logger.Printf("-------------------------------------------------------\n")
+				logger.Warnf("%v feed has no records\n", e.Name())
 			}
-			logger.Printf("\n")
-			emitters = append(emitters, seaflogData)
+		}
+		checkOverlap(emitters)
+		if reportGapsFlag > 0 {
+			reportGaps(emitters, reportGapsFlag)
 		}
 
 		if (len(emitters) > 0) {
@@ -183,30 +361,175 @@ Supported data feeds are:
 			// ***************************************************************
 			// Cruise-time start
 			if cruiseStart.IsZero() {
+				// Anchor to the first record, after any --filter-from
 				cruiseStart = minTime(emitters)
+				if cruiseStart.IsZero() {
+					logger.Fatalf("error: no records to replay\n")
+				}
 			}
-			delay, err := time.ParseDuration("5s")
-			if err != nil {
-				panic(err)
-			}
-			// Replay-time start with small delay
-			replayStart := time.Now().Add(delay)
+			delay := startInFlag
+			// Stop all feeds on the first interrupt or SIGTERM, closing them cleanly
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// Feeds are closed explicitly once the replay ends so errors are
+			// logged; this only catches a panic before then
+			closed := false
+			defer func() {
+				if !closed {
+					closeEmitters(emitters)
+				}
+			}()
 
+			dataEnd := maxTime(emitters)
+			logger.Detailf("data span = %v to %v (%v)\n", minTime(emitters), dataEnd, dataEnd.Sub(minTime(emitters)))
 			logger.Printf("cruise start = %v\n", cruiseStart)
-			logger.Printf("replay cruise start = %v\n", replayStart)
+			if cruiseStart.After(dataEnd) {
+				logger.Fatalf("error: cruise start %v is after the last record at %v\n", cruiseStart, dataEnd)
+			}
+			if !cruiseEnd.IsZero() {
+				if !cruiseEnd.After(cruiseStart) {
+					logger.Fatalf("error: --end must be after cruise start %v\n", cruiseStart)
+				}
+				if cruiseEnd.After(dataEnd) {
+					logger.Warnf("--end %v is after the last record at %v\n", cruiseEnd, dataEnd)
+				}
+				logger.Printf("cruise end = %v\n", cruiseEnd)
+			}
+
+			states := make([]*feedState, len(emitters))
+			for i := range states {
+				states[i] = &feedState{}
+			}
+			// Last cruise time replayed, for the projected finish
+			lastCruise := dataEnd
+			if !cruiseEnd.IsZero() && cruiseEnd.Before(lastCruise) {
+				lastCruise = cruiseEnd
+			}
+			eta := &replayETA{}
+			pause := newReplayPause()
+
+			if !finishBy.IsZero() {
+				// Measured from when the first record is scheduled
+				w, err := finishByWarp(lastCruise.Sub(cruiseStart), time.Until(finishBy)-delay)
+				if err != nil {
+					logger.Fatalf("error: --finish-by: %v\n", err)
+				}
+				warps.base = w
+				logger.Printf("--finish-by %v, replaying at warp %.4g\n", finishBy, w)
+			}
+			// Every feed shares the cruise start and replay start anchors, only
+			// the rate differs
+			feedWarps := make([]warpSchedule, len(emitters))
+			for i, e := range emitters {
+				feedWarps[i] = feedWarp(e.Name(), warps)
+			}
 
-			done := make(chan bool)
+			if statusAddrFlag != "" {
+				statusCtx, stopStatus := context.WithCancel(ctx)
+				defer stopStatus()
+				if err := serveStatus(statusCtx, statusAddrFlag, emitters, states, eta); err != nil {
+					logger.Fatalf("error: --status-addr: %v\n", err)
+				}
+				logger.Printf("serving replay status on %v\n", statusAddrFlag)
+			}
+
+			dumpCtx, stopDump := context.WithCancel(ctx)
+			defer stopDump()
+			go dumpStateOnHangup(dumpCtx, emitters, states, eta)
+			if !dryRunFlag {
+				go togglePauseOnSignal(dumpCtx, pause, eta)
+			}
 
-			for _, e := range emitters {
-				go startEmitter(e, cruiseStart, replayStart, warpFlag, done)
-				defer e.Close()
+			if progressFlag > 0 && !quietFlag {
+				progressCtx, stopProgress := context.WithCancel(ctx)
+				defer stopProgress()
+				go reportProgress(progressCtx, emitters, progressFlag, eta)
 			}
 
-			logger.Printf("waiting on %d feeds\n", len(emitters))
-			for range emitters {
-				<-done
+			// Stopped before feeds are closed, not just on interrupt
+			heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+			defer stopHeartbeat()
+			if heartbeatInterval > 0 && !dryRunFlag {
+				go sendHeartbeats(heartbeatCtx, findUnderway(emitters), heartbeatInterval, heartbeatPayload)
+			}
+
+			for pass := 0; ; pass++ {
+				if pass > 0 {
+					logger.Printf("restarting replay, loop %d\n", pass)
+					for _, e := range emitters {
+						if err := e.Reset(); err != nil {
+							logger.Fatalf("%v", err)
+						}
+					}
+				}
+				if seekFlag {
+					if !seekEmitters(emitters, cruiseStart) {
+						logger.Fatalf("error: --start %v is after the last record of every feed\n", cruiseStart)
+					}
+				}
+
+				// Replay-time start after --start-in
+				sched := replaySchedule{
+					cruiseStart: cruiseStart,
+					cruiseEnd:   cruiseEnd,
+					replayStart: time.Now().Add(delay),
+					warp:        warps,
+					dryRun:      dryRunFlag,
+					lagWarn:     lagWarnFlag,
+					catchUp:     catchUpFlag,
+					jitter:      jitterFlag,
+					seed:        seedFlag + int64(pass),
+					pause:       pause,
+					pauseBase:   pause.offset(),
+					clock:       wallClock{},
+				}
+				logger.Printf("replay cruise start = %v\n", sched.replayStart)
+				if !dryRunFlag {
+					// The feed that finishes last, at its own warp
+					var finish time.Time
+					for i, e := range emitters {
+						last := e.Latest()
+						if last.After(lastCruise) {
+							last = lastCruise
+						}
+						feedSched := sched
+						feedSched.warp = feedWarps[i]
+						if t, err := feedSched.scheduleTime(last); err == nil && t.After(finish) {
+							finish = t
+						}
+					}
+					eta.set(finish)
+					logETA(eta)
+				}
+
+				logger.Detailf("replaying %d feeds\n", len(emitters))
+				replayFeeds(ctx, sched, emitters, feedWarps, states)
+				if !dryRunFlag && !quietFlag {
+					reportLag(emitters, states)
+				}
+				if ctx.Err() != nil {
+					logger.Printf("interrupted, closing\n")
+					break
+				}
+				if loopFlag < 0 || (loopFlag > 0 && pass >= loopFlag) {
+					logger.Printf("all feeds complete, closing\n")
+					break
+				}
+			}
+			stopHeartbeat()
+			closed = true
+			if n := closeEmitters(emitters); n > 0 {
+				logger.Errorf("%d feeds failed to close, output may be incomplete\n", n)
+			}
+			if !dryRunFlag {
+				// Keep stdout to the underway data when it's written there
+				summary := os.Stdout
+				if underwayOutFlag == "stdout" {
+					summary = os.Stderr
+				}
+				reportSummary(summary, emitters, states)
 			}
-			fmt.Println("all feeds complete, closing")
 		}
 	},
 }
@@ -218,26 +541,176 @@ func Execute() {
 }
 
 func init() {
-	logger = log.New(os.Stderr, "", 0)
+	logger = newReplayLogger(os.Stderr)
+	rootCmd.PersistentPreRunE = applyConfig
+
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "",
+		"file of flag settings in a flat subset of YAML or TOML, overridden by flags on the command line")
 
 	rootCmd.PersistentFlags().StringVar(&evtDirFlag, "evt", "", "EVT directory")
-	rootCmd.PersistentFlags().StringVar(&underwayFileFlag, "underway", "", "underway raw feed file")
-	rootCmd.PersistentFlags().StringVar(&instrumentLogFlag, "seaflowlog", "", "SeaFlow instrument log file")
+	rootCmd.PersistentFlags().StringVar(&oppDirFlag, "opp", "", "OPP directory, legacy binary or parquet files")
+	rootCmd.PersistentFlags().StringVar(&underwayFileFlag, "underway", "",
+		"underway raw feed files, comma-separated paths or glob patterns")
+	rootCmd.PersistentFlags().StringVar(&underwayParserFlag, "underway-parser", "Kilo Moana", "underway feed parser")
+	rootCmd.PersistentFlags().StringVar(&instrumentLogFlag, "seaflowlog", "", "SeaFlow instrument log file, - for stdin")
+	rootCmd.PersistentFlags().StringArrayVar(&genericFlag, "generic", nil,
+		"timestamped TSV or CSV feed as file:col:layout:outpath, where col is the zero-based timestamp column, "+
+			"layout is a Go time layout or RFC3339, and outpath is relative to --outdir. Repeatable")
+	rootCmd.PersistentFlags().StringArrayVar(&globFeedFlag, "glob-feed", nil,
+		"files copied at the times in their names, as glob:outdir:layout:regex, where regex finds the timestamp "+
+			"in each file name (its first group if any), layout is a Go time layout, and outdir is relative to --outdir. Repeatable")
+	rootCmd.PersistentFlags().BoolVar(&evtEmitFlag, "evt-emit", true,
+		"write EVT files to --outdir. --evt-emit=false still schedules them, unlike --dry-run, to test other feeds' timing without EVT I/O")
+	rootCmd.PersistentFlags().StringVar(&evtLinkFlag, "evt-link", "copy",
+		"how EVT files are placed in --outdir: copy, hardlink, or symlink. Linking falls back to copying "+
+			"for gzipped files or a different filesystem")
+	rootCmd.PersistentFlags().DurationVar(&evtMtimeSkewFlag, "evt-mtime-skew", 0,
+		"warn about EVT files modified further than this from their filename timestamp, 0 to skip")
+	rootCmd.PersistentFlags().IntVar(&evtSampleFlag, "evt-sample", 0,
+		"replay only every Nth EVT file, for shorter test runs. Not for production replays")
+	rootCmd.PersistentFlags().IntVar(&underwaySampleFlag, "underway-sample", 0,
+		"replay only every Nth underway record, for shorter test runs. Not for production replays")
+	rootCmd.PersistentFlags().BoolVar(&compressSflFlag, "compress-sfl", false, "write gzipped SFL output files")
+	rootCmd.PersistentFlags().StringVar(&sflUDPFlag, "sfl-udp", "",
+		"also send each SFL record as a UDP datagram to host:port")
+	rootCmd.PersistentFlags().BoolVar(&sflUDPOnlyFlag, "sfl-udp-only", false,
+		"with --sfl-udp, don't write SFL output files")
+	rootCmd.PersistentFlags().BoolVar(&fsyncFlag, "fsync", false,
+		"sync SFL and SeaFlow log output to disk after every record, slower but visible to watchers immediately")
+	rootCmd.PersistentFlags().StringSliceVar(&logEventsFlag, "log-events", nil,
+		"replay only SeaFlow instrument log events with these comma-separated names, e.g. PMT1,PMT2")
+	rootCmd.PersistentFlags().BoolVar(&verbatimLogFlag, "verbatim-log", false,
+		"write SeaFlow log events byte for byte as in the input instead of reformatting them")
 	rootCmd.PersistentFlags().StringVar(&outDirFlag, "outdir", "cruisereplay_out",
 		"output directory")
+	rootCmd.PersistentFlags().StringVar(&pathTemplateFlag, "path-template", "",
+		"Go template for EVT, SFL, and OPP output paths under --outdir, using .Feed, .Time, .Year, .YearDay, and .Base. "+
+			"Default is datafiles/evt/<year>_<doy>/<file>, or datafiles/opp/... for OPP")
+	rootCmd.PersistentFlags().StringVar(&dirModeFlag, "dir-mode", "0755", "octal permissions of created output directories")
+	rootCmd.PersistentFlags().StringVar(&fileModeFlag, "file-mode", "0644", "octal permissions of created output files")
+	rootCmd.PersistentFlags().IntVar(&maxCopiesFlag, "max-copies", 0,
+		"most EVT, OPP, and glob feed file copies to run at once, queueing EVT copies in the background, 0 for no limit")
+	rootCmd.PersistentFlags().IntVar(&copyBufferFlag, "copy-buffer", 0,
+		"EVT and OPP copy buffer size in bytes, 0 for the 32KB default")
+	rootCmd.PersistentFlags().BoolVar(&manifestFlag, "manifest", false,
+		"write the SHA-256 of every EVT, SFL, and OPP output file to manifest.tsv in --outdir")
 	rootCmd.PersistentFlags().StringVar(&startFlag, "start", "",
 		"RFC3339 timestamp for replay start, in cruise time")
+	rootCmd.PersistentFlags().StringVar(&endFlag, "end", "",
+		"RFC3339 timestamp for replay end, in cruise time")
+	rootCmd.PersistentFlags().StringVar(&filterFromFlag, "filter-from", "",
+		"RFC3339 timestamp, only replay records at or after this cruise time")
+	rootCmd.PersistentFlags().StringVar(&filterToFlag, "filter-to", "",
+		"RFC3339 timestamp, only replay records at or before this cruise time")
+	rootCmd.PersistentFlags().StringVar(&tzFlag, "tz", "",
+		"IANA time zone, e.g. Pacific/Honolulu, to honor timestamp offsets in and name day of year directories by. "+
+			"By default every timestamp is read as UTC")
+	rootCmd.PersistentFlags().BoolVar(&sinceNowFlag, "since-now", false,
+		"shift every record so the first is dated when the replay starts, as if the cruise were happening now")
+	rootCmd.PersistentFlags().DurationVar(&shiftTimeFlag, "shift-time", 0,
+		"add this to every record time as feeds are read, e.g. to replay an old cruise with recent dates. "+
+			"--start, --end, and the filters are in shifted time")
 	rootCmd.PersistentFlags().Float64Var(&warpFlag, "warp", 1.0,
 		"time speedup/slowdown factor")
-	rootCmd.PersistentFlags().UintVar(&udpPortFlag, "port", 5555, "UDP destination port")
-	rootCmd.PersistentFlags().StringVar(&udpHostFlag, "host", "255.255.255.255", "UDP destination IP address")
+	rootCmd.PersistentFlags().Float64Var(&evtWarpFlag, "evt-warp", 0,
+		"warp factor for the EVT feed, replacing --warp and --warp-schedule, 0 to use them")
+	rootCmd.PersistentFlags().Float64Var(&sflWarpFlag, "sfl-warp", 0,
+		"warp factor for the SFL feed, replacing --warp and --warp-schedule, 0 to use them")
+	rootCmd.PersistentFlags().Float64Var(&oppWarpFlag, "opp-warp", 0,
+		"warp factor for the OPP feed, replacing --warp and --warp-schedule, 0 to use them")
+	rootCmd.PersistentFlags().Float64Var(&underwayWarpFlag, "underway-warp", 0,
+		"warp factor for the underway feed, replacing --warp and --warp-schedule, 0 to use them")
+	rootCmd.PersistentFlags().Float64Var(&seaflowlogWarpFlag, "seaflowlog-warp", 0,
+		"warp factor for the SeaFlow log feed, replacing --warp and --warp-schedule, 0 to use them")
+	rootCmd.PersistentFlags().Float64Var(&genericWarpFlag, "generic-warp", 0,
+		"warp factor for every --generic feed, replacing --warp and --warp-schedule, 0 to use them")
+	rootCmd.PersistentFlags().StringVar(&finishByFlag, "finish-by", "",
+		"RFC3339 wall-clock deadline, replay at the warp factor that ends the replay then instead of --warp")
+	rootCmd.PersistentFlags().StringVar(&warpScheduleFlag, "warp-schedule", "",
+		"per-range warp factors as start-end:warp in seconds from cruise start, e.g. 0-3600:10,3600-4000:1. "+
+			"Time outside the ranges uses --warp")
+	rootCmd.PersistentFlags().UintVar(&udpPortFlag, "port", 5555, "underway destination port")
+	rootCmd.PersistentFlags().StringVar(&udpHostFlag, "host", "255.255.255.255",
+		"underway destination IP address, must be changed from the broadcast default for TCP")
+	rootCmd.PersistentFlags().StringVar(&protoFlag, "proto", "udp", "underway feed protocol, udp or tcp")
+	rootCmd.PersistentFlags().StringVar(&multicastIfaceFlag, "multicast-interface", "",
+		"network interface to send underway multicast data through")
+	rootCmd.PersistentFlags().StringVar(&underwayOutFlag, "underway-out", "",
+		"send underway data to a serial device, as serial:device:baud, or to standard output with stdout, instead of the network")
+	rootCmd.PersistentFlags().BoolVar(&fixChecksumFlag, "fix-nmea-checksum", false,
+		"recompute the *HH checksum of underway NMEA sentences before sending")
+	rootCmd.PersistentFlags().BoolVar(&coalesceAllFlag, "coalesce-all", false,
+		"send all underway records in the same second together instead of one record type per send")
+	rootCmd.PersistentFlags().BoolVar(&noCoalesceFlag, "no-coalesce", false,
+		"send each underway record on its own at its original time instead of merging records in the same second")
+	rootCmd.PersistentFlags().BoolVar(&subsecondFlag, "underway-subsecond", false,
+		"send each merged underway record at the sub-second time of its first line instead of the start of its second")
+	rootCmd.PersistentFlags().BoolVar(&udpSplitFlag, "udp-split", false,
+		"send each line of a coalesced underway record as its own datagram")
+	rootCmd.PersistentFlags().DurationVar(&udpSplitDelayFlag, "udp-split-delay", 0,
+		"pause between datagrams of one record with --udp-split or --max-udp-payload, e.g. 5ms")
+	rootCmd.PersistentFlags().IntVar(&maxUDPPayloadFlag, "max-udp-payload", 0,
+		"split underway records into datagrams of at most this many bytes on line boundaries, e.g. 1472, 0 for no limit")
+	rootCmd.PersistentFlags().IntVar(&udpRetriesFlag, "udp-retries", 3,
+		"retry an underway write this many times with backoff on transient errors like ENOBUFS before dropping it")
+	rootCmd.PersistentFlags().StringVar(&udpTeeFlag, "udp-tee", "",
+		"append every underway payload sent, after a line with its send time and length, to this file")
+	rootCmd.PersistentFlags().StringVar(&udpHeartbeatFlag, "udp-heartbeat", "",
+		"send a keepalive on the underway destination every interval of wall-clock time during the replay, "+
+			"as interval[:payload], e.g. 30s or 10s:$PXXX,ALIVE. The default payload is "+defaultHeartbeat)
+	rootCmd.PersistentFlags().IntVar(&multicastTTLFlag, "multicast-ttl", 0,
+		"underway multicast TTL, 0 for the system default")
 	rootCmd.PersistentFlags().Int64Var(&underwayThrottleFlag, "throttle", 60, "produce UDP feed data at most every N sec")
+	rootCmd.PersistentFlags().StringSliceVar(&throttleTypeFlag, "throttle-type", nil,
+		"throttle these underway record types at their own interval instead of --throttle, as TYPE:N sec, "+
+			"e.g. GGA:5,MWD:60. TYPE is a full NMEA type like GPGGA, a sentence ID like GGA for any talker, or a parser record type")
+	rootCmd.PersistentFlags().IntVar(&maxWarningsFlag, "max-warnings", 0,
+		"exit at startup if reading any one feed gives more than this many warnings, 0 for no limit")
+	rootCmd.PersistentFlags().BoolVar(&dedupeFlag, "dedupe", false,
+		"drop SFL, underway, instrument log, and generic records with the same time and text as an earlier record of the feed")
+	rootCmd.PersistentFlags().StringVar(&warningsFileFlag, "warnings-file", "",
+		"write every warning from reading the feeds to this file as JSON, with its feed, file, line, and message")
+	rootCmd.PersistentFlags().BoolVar(&requireNonemptyFlag, "require-nonempty", false,
+		"exit at startup if any requested feed has no records")
+	rootCmd.PersistentFlags().DurationVar(&reportGapsFlag, "report-gaps", 0,
+		"after loading, log gaps between records longer than this, 0 to skip")
+	rootCmd.PersistentFlags().DurationVar(&startInFlag, "start-in", 5*time.Second,
+		"wait this long after loading before the first record is emitted")
+	rootCmd.PersistentFlags().DurationVar(&jitterFlag, "jitter", 0,
+		"move each scheduled emit by a random offset of up to this much either way, keeping each feed in order")
+	rootCmd.PersistentFlags().Int64Var(&seedFlag, "seed", 0,
+		"random seed for --jitter, 0 to pick one and log it")
+	rootCmd.PersistentFlags().BoolVar(&catchUpFlag, "catch-up", false,
+		"emit past-due records, including those before --start unless --seek is set, immediately "+
+			"without timers or per-record logging")
+	rootCmd.PersistentFlags().BoolVar(&seekFlag, "seek", false,
+		"skip records before --start instead of scanning past them during replay")
+	rootCmd.PersistentFlags().DurationVar(&progressFlag, "progress", 10*time.Second,
+		"log per-feed progress at this interval, 0 to disable")
+	rootCmd.PersistentFlags().IntVar(&loopFlag, "loop", -1,
+		"replay N more times after the first pass, 0 to loop forever, -1 to disable")
+	rootCmd.PersistentFlags().BoolVar(&dryRunFlag, "dry-run", false,
+		"print the emit schedule without waiting, writing files, or sending data")
+	rootCmd.PersistentFlags().StringVar(&verbosityFlag, "verbosity", "info",
+		"log level, one of error, warn, info, debug. Per-record timer messages are debug")
+	rootCmd.PersistentFlags().BoolVar(&quietFlag, "quiet", false,
+		"only log replay start and finish, warnings, and errors, leaving out banners and progress")
+	rootCmd.PersistentFlags().DurationVar(&lagWarnFlag, "lag-warn", time.Second,
+		"warn when a feed finishes emitting a record this long after it was scheduled")
+	rootCmd.PersistentFlags().StringVar(&statusAddrFlag, "status-addr", "",
+		"serve JSON replay status over HTTP on this address, e.g. :8080")
+	rootCmd.PersistentFlags().StringVar(&logFormatFlag, "log-format", "text", "log format, text or json")
 	rootCmd.PersistentFlags().BoolVar(&versionFlag, "version", false, "print version and exit")
 }
 
+// minTime returns the earliest record time of all emitters, ignoring empty
+// ones. It's zero if every emitter is empty.
 func minTime(es []feeds.Emitter) (first time.Time) {
 	for _, e := range es {
-		logger.Printf("%v\n", e.Earliest())
+		logger.Debugf("%v earliest = %v\n", e.Name(), e.Earliest())
+		if e.Len() == 0 {
+			continue
+		}
 		if first.IsZero() || e.Earliest().Before(first) {
 			first = e.Earliest()
 		}
@@ -245,28 +718,259 @@ func minTime(es []feeds.Emitter) (first time.Time) {
 	return
 }
 
-func startEmitter(e feeds.Emitter, cruiseStart, replayStart time.Time, warp float64, done chan bool) {
-	for e.Next() {
-		if e.Time().Before(cruiseStart) {
+// maxTime returns the latest record time of all emitters. It's zero if every
+// emitter is empty.
+func maxTime(es []feeds.Emitter) (last time.Time) {
+	for _, e := range es {
+		if e.Latest().After(last) {
+			last = e.Latest()
+		}
+	}
+	return
+}
+
+// checkNames returns an error if two emitters have the same name, since logs
+// and status output identify feeds by name.
+func checkNames(es []feeds.Emitter) error {
+	seen := make(map[string]bool)
+	for _, e := range es {
+		if seen[e.Name()] {
+			return fmt.Errorf("more than one feed is named %q", e.Name())
+		}
+		seen[e.Name()] = true
+	}
+	return nil
+}
+
+// checkOutDir creates subdirs under dir with permissions mode and checks that
+// files can be created in each of them.
+func checkOutDir(dir string, mode os.FileMode, subdirs ...string) error {
+	for _, sub := range subdirs {
+		path := filepath.Join(dir, sub)
+		if err := os.MkdirAll(path, mode); err != nil {
+			return err
+		}
+		probe, err := os.CreateTemp(path, ".cruisereplay-probe-")
+		if err != nil {
+			return fmt.Errorf("%v is not writable: %v", path, err)
+		}
+		probe.Close()
+		if err := os.Remove(probe.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandPaths splits a comma-separated list of paths and glob patterns into
+// a list of paths. Patterns are expanded in sorted order and must match at
+// least one file.
+func expandPaths(spec string) (paths []string, err error) {
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
 			continue
 		}
-		// Duration between cruise start with offset and this point
-		delta := e.Time().Sub(cruiseStart)
-		// Adjust for time warp
-		delta = time.Duration(float64(delta.Nanoseconds()) / warp)
-		if delta < 0 {
-			panic(fmt.Errorf("delta < 0, %v, for %v", delta, e.Time()))
-		}
-		emitTime := replayStart.Add(delta) // when to emit
-		untilEmit := time.Until(emitTime)  // how long until emit
-		logger.Printf("%v timer set for %v in %v\n", e.Name(), emitTime.UTC(), untilEmit)
-		timer := time.NewTimer(untilEmit)
-		<-timer.C
-		logger.Printf("%v timer fired at %v\n", e.Name(), time.Now().UTC())
-		err := e.Emit()
+		matches, err := filepath.Glob(p)
 		if err != nil {
-			log.Printf("%v", err)
+			return nil, fmt.Errorf("%v: %v", p, err)
+		}
+		if len(matches) == 0 {
+			if !strings.ContainsAny(p, "*?[") {
+				// Plain path, leave it to the caller to report if missing
+				paths = append(paths, p)
+				continue
+			}
+			return nil, fmt.Errorf("no files match %v", p)
 		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files in %q", spec)
+	}
+	return paths, nil
+}
+
+// parseGenericSpec splits a --generic value of the form file:col:layout:outpath.
+// The layout may itself contain colons, so file and col are taken from the
+// front and outpath from the end. A layout of RFC3339 means time.RFC3339.
+func parseGenericSpec(spec string) (file string, col int, layout string, outPath string, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 4 {
+		return "", 0, "", "", fmt.Errorf("%q is not file:col:layout:outpath", spec)
+	}
+	file = parts[0]
+	outPath = parts[len(parts)-1]
+	layout = strings.Join(parts[2:len(parts)-1], ":")
+	if col, err = strconv.Atoi(parts[1]); err != nil {
+		return "", 0, "", "", fmt.Errorf("%q: bad column %q", spec, parts[1])
+	}
+	if layout == "RFC3339" {
+		layout = time.RFC3339
+	}
+	if file == "" || layout == "" || outPath == "" {
+		return "", 0, "", "", fmt.Errorf("%q is not file:col:layout:outpath", spec)
+	}
+	return
+}
+
+// parseGlobFeedSpec splits a --glob-feed value of the form
+// glob:outdir:layout:regex. File names can't hold colons on every platform,
+// so neither can the layout, but the regex may and is taken from the end.
+func parseGlobFeedSpec(spec string) (pattern string, outDir string, layout string, re *regexp.Regexp, err error) {
+	parts := strings.SplitN(spec, ":", 4)
+	if len(parts) < 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return "", "", "", nil, fmt.Errorf("%q is not glob:outdir:layout:regex", spec)
+	}
+	pattern, outDir, layout = parts[0], parts[1], parts[2]
+	if re, err = regexp.Compile(parts[3]); err != nil {
+		return "", "", "", nil, fmt.Errorf("%q: bad regex: %v", spec, err)
+	}
+	return
+}
+
+// parseThrottleTypes parses --throttle-type values of the form TYPE:N, where
+// N is whole seconds, into intervals by record type.
+func parseThrottleTypes(specs []string) (map[string]time.Duration, error) {
+	throttles := make(map[string]time.Duration)
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not TYPE:N", spec)
+		}
+		sec, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || sec < 0 {
+			return nil, fmt.Errorf("%q: bad interval %q, want whole seconds of at least 0", spec, parts[1])
+		}
+		if _, ok := throttles[parts[0]]; ok {
+			return nil, fmt.Errorf("%q: %s given more than once", spec, parts[0])
+		}
+		throttles[parts[0]] = time.Duration(sec) * time.Second
+	}
+	return throttles, nil
+}
+
+// parseSerialSpec parses an --underway-out value of the form
+// serial:device:baud.
+func parseSerialSpec(spec string) (t feeds.Transport, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 || parts[0] != "serial" || parts[1] == "" {
+		return t, fmt.Errorf("%q is not serial:device:baud", spec)
+	}
+	baud, err := strconv.Atoi(parts[2])
+	if err != nil || baud <= 0 {
+		return t, fmt.Errorf("%q: bad baud rate %q", spec, parts[2])
+	}
+	return feeds.Transport{Proto: "serial", Device: parts[1], Baud: baud}, nil
+}
+
+// parseUDPSpec parses a host:port UDP destination such as --sfl-udp. The host
+// may be a unicast, broadcast, or multicast address; multicast sends use
+// --multicast-interface and --multicast-ttl like the underway feed.
+func parseUDPSpec(spec string) (t feeds.Transport, err error) {
+	host, portStr, err := net.SplitHostPort(spec)
+	if err != nil {
+		return t, err
+	}
+	if host == "" {
+		return t, fmt.Errorf("%q has no host", spec)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return t, fmt.Errorf("%q: bad port %q", spec, portStr)
+	}
+	t = feeds.Transport{Proto: "udp", Host: host, Port: uint(port)}
+	if ip := net.ParseIP(host); ip != nil && ip.IsMulticast() {
+		t.Interface = multicastIfaceFlag
+		t.TTL = multicastTTLFlag
+	}
+	return t, nil
+}
+
+// seekEmitters positions every emitter at the first record at or after t. It
+// reports whether any emitter has records left to replay.
+func seekEmitters(es []feeds.Emitter, t time.Time) (ok bool) {
+	for _, e := range es {
+		if e.Seek(t) {
+			ok = true
+		}
+	}
+	return
+}
+
+// reportProgress logs how far along each emitter is, and the projected finish,
+// every interval until ctx is cancelled.
+func reportProgress(ctx context.Context, es []feeds.Emitter, interval time.Duration, eta *replayETA) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, e := range es {
+				done, total := e.Progress()
+				pct := 0.0
+				if total > 0 {
+					pct = float64(done) / float64(total) * 100
+				}
+				logger.Printf("%v: %d/%d (%.1f%%)\n", e.Name(), done, total, pct)
+			}
+			logETA(eta)
+		}
+	}
+}
+
+// dumpStateOnHangup logs a snapshot of every feed each time the process
+// receives SIGHUP, until ctx is cancelled.
+func dumpStateOnHangup(ctx context.Context, es []feeds.Emitter, states []*feedState, eta *replayETA) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			for _, st := range replayStatus(es, states) {
+				logger.Log(levelInfo, fmt.Sprintf("%v: current %v, next %v, %d/%d\n", st.Name, st.Current, st.Next.UTC(), st.Done, st.Total),
+					fields{"feed": st.Name, "event": "state", "current": st.Current, "next": st.Next.UTC(), "done": st.Done, "total": st.Total})
+			}
+			logETA(eta)
+		}
+	}
+}
+
+// closeEmitters closes every emitter, flushing any buffered output, and logs
+// each error. It returns the number of emitters that failed to close.
+func closeEmitters(es []feeds.Emitter) (failed int) {
+	for _, e := range es {
+		if err := e.Close(); err != nil {
+			logger.Log(levelError, fmt.Sprintf("%v\n", err), fields{"feed": e.Name(), "event": "close"})
+			failed++
+		}
+	}
+	return failed
+}
+
+// reportSummary prints a table to w with a row per feed of its record
+// counts, time range, and emit lag over the whole run.
+func reportSummary(w io.Writer, es []feeds.Emitter, states []*feedState) {
+	fmt.Fprintf(w, "feed\tloaded\temitted\tskipped\tfailed\twarnings\tearliest\tlatest\tlag_avg\tlag_max\tbytes\tpeak_bytes_per_sec\n")
+	for i, e := range es {
+		st := e.Stats()
+		sent, warned, failed := states[i].counts()
+		avg, max := states[i].lag()
+		fmt.Fprintf(w, "%v\t%d\t%d\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t%d\t%d\n", e.Name(), st.Len, sent, warned, failed,
+			st.Warnings+warned, formatTime(st.Earliest), formatTime(st.Latest), avg, max, st.Bytes, states[i].peakRate())
+	}
+}
+
+// reportLag logs the average and maximum emit latency of each feed.
+func reportLag(es []feeds.Emitter, states []*feedState) {
+	for i, e := range es {
+		avg, max := states[i].lag()
+		logger.Log(levelInfo, fmt.Sprintf("%v: emit lag avg %v, max %v\n", e.Name(), avg, max),
+			fields{"feed": e.Name(), "event": "lag", "lag_avg": avg.String(), "lag_max": max.String()})
 	}
-	done <- true
 }
@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+var (
+	testCruiseStart = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	testReplayStart = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+)
+
+// testLogger logs to a buffer for the rest of the test.
+func testLogger(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var b bytes.Buffer
+	orig := logger
+	t.Cleanup(func() { logger = orig })
+	logger = newReplayLogger(&b)
+	return &b
+}
+
+// replayEmit is one emit seen by a timedEmitter.
+type replayEmit struct {
+	payload string
+	at      time.Time // clock time of the emit
+}
+
+// timedEmitter is a MemEmitter that adds each emit and the clock's time at it
+// to a log shared by every feed of a test replay.
+type timedEmitter struct {
+	*feeds.MemEmitter
+	clock clock
+	log   *[]replayEmit
+}
+
+func (e *timedEmitter) Emit() error {
+	if err := e.MemEmitter.Emit(); err != nil {
+		return err
+	}
+	emitted := e.MemEmitter.Emitted()
+	*e.log = append(*e.log, replayEmit{emitted[len(emitted)-1], e.clock.Now()})
+	return nil
+}
+
+// panicEmitter is a MemEmitter whose Emit panics at its record at cruise
+// time at.
+type panicEmitter struct {
+	*feeds.MemEmitter
+	at time.Time
+}
+
+func (e *panicEmitter) Emit() error {
+	if e.Time().Equal(e.at) {
+		panic("emit failed")
+	}
+	return e.MemEmitter.Emit()
+}
+
+// memFeed returns a feed named name with a record at each offset from
+// testCruiseStart, whose payload is name@offset.
+func memFeed(name string, offsets ...time.Duration) *feeds.MemEmitter {
+	var records []feeds.MemRecord
+	for _, off := range offsets {
+		records = append(records, feeds.MemRecord{Time: testCruiseStart.Add(off), Payload: fmt.Sprintf("%s@%v", name, off)})
+	}
+	return feeds.NewMemEmitter(name, records)
+}
+
+// testSchedule returns a schedule replaying testCruiseStart at testReplayStart
+// on a fake clock.
+func testSchedule() replaySchedule {
+	return replaySchedule{
+		cruiseStart: testCruiseStart,
+		replayStart: testReplayStart,
+		warp:        warpSchedule{base: 1},
+		lagWarn:     time.Second,
+		clock:       newFakeClock(testReplayStart),
+	}
+}
+
+// runReplay replays es on sched, each at its warp in warps, and returns every
+// emit in order.
+func runReplay(t *testing.T, sched replaySchedule, warps []warpSchedule, es ...feeds.Emitter) []replayEmit {
+	t.Helper()
+	var log []replayEmit
+	states := make([]*feedState, len(es))
+	for i, e := range es {
+		if m, ok := e.(*feeds.MemEmitter); ok {
+			es[i] = &timedEmitter{m, sched.clock, &log}
+		}
+		states[i] = &feedState{}
+	}
+	replayFeeds(context.Background(), sched, es, warps, states)
+	return log
+}
+
+func TestReplayFeedsOrderAndTimes(t *testing.T) {
+	testLogger(t)
+	s := time.Second
+	segments, err := parseWarpSchedule("0-20:10", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		warps []warpSchedule
+		want  []string
+		at    []time.Duration // after testReplayStart, for each of want
+	}{
+		{
+			name:  "real time",
+			warps: []warpSchedule{{base: 1}, {base: 1}},
+			want:  []string{"a@0s", "b@10s", "a@20s", "b@20s", "b@30s", "a@40s"},
+			at:    []time.Duration{0, 10 * s, 20 * s, 20 * s, 30 * s, 40 * s},
+		},
+		{
+			name:  "sped up",
+			warps: []warpSchedule{{base: 10}, {base: 10}},
+			want:  []string{"a@0s", "b@10s", "a@20s", "b@20s", "b@30s", "a@40s"},
+			at:    []time.Duration{0, s, 2 * s, 2 * s, 3 * s, 4 * s},
+		},
+		{
+			name:  "slowed down",
+			warps: []warpSchedule{{base: 0.5}, {base: 0.5}},
+			want:  []string{"a@0s", "b@10s", "a@20s", "b@20s", "b@30s", "a@40s"},
+			at:    []time.Duration{0, 20 * s, 40 * s, 40 * s, 60 * s, 80 * s},
+		},
+		{
+			name:  "per-feed warps",
+			warps: []warpSchedule{{base: 1}, {base: 2}},
+			want:  []string{"a@0s", "b@10s", "b@20s", "b@30s", "a@20s", "a@40s"},
+			at:    []time.Duration{0, 5 * s, 10 * s, 15 * s, 20 * s, 40 * s},
+		},
+		{
+			name:  "warp schedule",
+			warps: []warpSchedule{segments, segments},
+			want:  []string{"a@0s", "b@10s", "a@20s", "b@20s", "b@30s", "a@40s"},
+			at:    []time.Duration{0, s, 2 * s, 2 * s, 12 * s, 22 * s},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := memFeed("a", 0, 20*s, 40*s)
+			b := memFeed("b", 10*s, 20*s, 30*s)
+			got := runReplay(t, testSchedule(), tt.warps, a, b)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d emits %v, want %v", len(got), got, tt.want)
+			}
+			for i, emit := range got {
+				if emit.payload != tt.want[i] {
+					t.Errorf("emit %d = %s, want %s", i, emit.payload, tt.want[i])
+				}
+				if want := testReplayStart.Add(tt.at[i]); !emit.at.Equal(want) {
+					t.Errorf("%s emitted at %v, want %v", emit.payload, emit.at, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayFeedsDryRunEmitsNothing(t *testing.T) {
+	out := testLogger(t)
+	sched := testSchedule()
+	sched.dryRun = true
+	a := memFeed("a", 0, time.Minute)
+	got := runReplay(t, sched, []warpSchedule{{base: 1}}, a)
+	if len(got) != 0 {
+		t.Errorf("dry run emitted %v", got)
+	}
+	if n := bytes.Count(out.Bytes(), []byte("a scheduled for")); n != 2 {
+		t.Errorf("dry run logged %d schedule lines, want 2:\n%s", n, out)
+	}
+	if now := sched.clock.Now(); !now.Equal(testReplayStart) {
+		t.Errorf("dry run waited until %v", now)
+	}
+}
+
+func TestScheduleTime(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name    string
+		warp    float64
+		record  time.Time
+		want    time.Duration // after testReplayStart
+		wantErr bool
+	}{
+		{"at cruise start", 1, testCruiseStart, 0, false},
+		{"real time", 1, testCruiseStart.Add(90 * s), 90 * s, false},
+		{"sped up", 10, testCruiseStart.Add(90 * s), 9 * s, false},
+		{"slowed down", 0.5, testCruiseStart.Add(90 * s), 180 * s, false},
+		{"fractional speedup", 3, testCruiseStart.Add(s), s / 3, false},
+		{"a nanosecond before cruise start", 1, testCruiseStart.Add(-1), 0, true},
+		{"a day before cruise start", 10, testCruiseStart.Add(-24 * time.Hour), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched := testSchedule()
+			sched.warp = warpSchedule{base: tt.warp}
+			got, err := sched.scheduleTime(tt.record)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("scheduleTime(%v) = %v, want a negative delta error", tt.record, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := testReplayStart.Add(tt.want); !got.Equal(want) {
+				t.Errorf("scheduleTime(%v) = %v, want %v", tt.record, got, want)
+			}
+		})
+	}
+}
+
+func TestReplayFeedsSkipsRecordBeforeCruiseStart(t *testing.T) {
+	testLogger(t)
+	a := memFeed("a", -1, 0, time.Second)
+	state := &feedState{}
+	sched := testSchedule()
+	var log []replayEmit
+	replayFeeds(context.Background(), sched, []feeds.Emitter{&timedEmitter{a, sched.clock, &log}}, []warpSchedule{{base: 1}}, []*feedState{state})
+
+	want := []replayEmit{{"a@0s", testReplayStart}, {"a@1s", testReplayStart.Add(time.Second)}}
+	if len(log) != len(want) {
+		t.Fatalf("emitted %v, want %v", log, want)
+	}
+	for i := range want {
+		if log[i].payload != want[i].payload || !log[i].at.Equal(want[i].at) {
+			t.Errorf("emit %d = %v, want %v", i, log[i], want[i])
+		}
+	}
+	if _, _, failed := state.counts(); failed != 0 {
+		t.Errorf("%d failed emits", failed)
+	}
+}
+
+func TestReplayFeedsPanicStopsOnlyThatFeed(t *testing.T) {
+	out := testLogger(t)
+	s := time.Second
+	a := memFeed("a", 0, 10*s, 20*s)
+	bad := &panicEmitter{memFeed("bad", 0, 5*s, 15*s), testCruiseStart.Add(5 * s)}
+	c := memFeed("c", 0, 10*s, 20*s)
+	sched := testSchedule()
+	var log []replayEmit
+	es := []feeds.Emitter{&timedEmitter{a, sched.clock, &log}, bad, &timedEmitter{c, sched.clock, &log}}
+	states := []*feedState{{}, {}, {}}
+
+	done := make(chan struct{})
+	go func() {
+		replayFeeds(context.Background(), sched, es, []warpSchedule{{base: 1}, {base: 1}, {base: 1}}, states)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay didn't return after a feed panicked")
+	}
+
+	want := []string{"a@0s", "c@0s", "a@10s", "c@10s", "a@20s", "c@20s"}
+	if len(log) != len(want) {
+		t.Fatalf("emitted %v, want %v", log, want)
+	}
+	for i := range want {
+		if log[i].payload != want[i] {
+			t.Errorf("emit %d = %s, want %s", i, log[i].payload, want[i])
+		}
+	}
+	if got := bad.Emitted(); len(got) != 1 || got[0] != "bad@0s" {
+		t.Errorf("panicking feed emitted %v, want only its record before the panic", got)
+	}
+	if _, _, failed := states[1].counts(); failed != 1 {
+		t.Errorf("panicking feed has %d failed emits, want 1", failed)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("bad panicked after the record at 2021-01-01 00:00:00 +0000 UTC")) {
+		t.Errorf("panic not logged with the feed's last record:\n%s", out)
+	}
+}
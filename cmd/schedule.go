@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"runtime/debug"
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// replaySchedule maps cruise time to replay time for one pass of a replay.
+type replaySchedule struct {
+	cruiseStart time.Time     // first cruise time to replay
+	cruiseEnd   time.Time     // last cruise time to replay, zero for no limit
+	replayStart time.Time     // wall-clock time at which cruiseStart is replayed
+	warp        warpSchedule  // time speedup/slowdown factors
+	dryRun      bool          // log the schedule without waiting or emitting
+	lagWarn     time.Duration // warn when an emit finishes this long after schedule
+	catchUp     bool          // emit past-due records immediately without timers
+	jitter      time.Duration // randomly move each scheduled emit by up to this much
+	seed        int64         // jitter random seed, combined with each feed's name
+	pause       *replayPause  // pause state shared by every feed, nil to never pause
+	pauseBase   time.Duration // pause.offset() when this pass started
+	clock       clock         // wall-clock time and timers for scheduling
+}
+
+// pauseShift returns how much later records are replayed because of pauses
+// during this pass.
+func (s replaySchedule) pauseShift() time.Duration {
+	return s.pause.offset() - s.pauseBase
+}
+
+// scheduleTime returns the wall-clock time at which a record at cruise time t
+// is replayed. It returns an error if t is before cruise start.
+func (s replaySchedule) scheduleTime(t time.Time) (time.Time, error) {
+	// Duration between cruise start with offset and this point
+	delta := t.Sub(s.cruiseStart)
+	if delta < 0 {
+		return time.Time{}, fmt.Errorf("delta < 0, %v, for %v", delta, t)
+	}
+	// Adjust for time warp
+	return s.replayStart.Add(s.warp.replayOffset(delta)), nil
+}
+
+// replayFeeds replays one pass of es, each at its own warp from warps and
+// otherwise according to sched, until every feed is exhausted or ctx is
+// cancelled. The feeds' next records are kept in one queue ordered by when
+// they're due, and a single timer sleeps until the nearest one, which is
+// emitted on its feed. A slow emit delays the records due after it, which
+// shows up as lag. A feed that panics is dropped and the others carry on.
+func replayFeeds(ctx context.Context, sched replaySchedule, es []feeds.Emitter, warps []warpSchedule, states []*feedState) {
+	cursors := make([]*feedCursor, len(es))
+	q := &emitterQueue{}
+	for i, e := range es {
+		c := newFeedCursor(e, sched, warps[i], states[i])
+		cursors[i] = c
+		if c.guard(c.advance) {
+			heap.Push(q, queuedEmitter{e: e, order: i, at: c.due})
+		}
+	}
+
+	// A single timer is re-armed for every record rather than allocating one
+	// per record
+	timer := sched.clock.NewTimer(0)
+	<-timer.C()
+	defer timer.Stop()
+
+	for q.Len() > 0 {
+		c := cursors[(*q)[0].order]
+		if ctx.Err() != nil {
+			cancelFeeds(q)
+			return
+		}
+		if sched.dryRun {
+			logger.Log(levelInfo, fmt.Sprintf("%v scheduled for %v to %v\n", c.name, c.due.UTC(), c.e.Target()),
+				fields{"feed": c.name, "event": "dry_run", "scheduled": c.due.UTC(), "target": c.e.Target()})
+		} else {
+			if err := sched.pause.wait(ctx); err != nil {
+				cancelFeeds(q)
+				return
+			}
+			emitTime := c.due.Add(sched.pauseShift())
+			c.state.scheduled(emitTime)
+			untilEmit := emitTime.Sub(sched.clock.Now()) // how long until emit
+			pastDue := sched.catchUp && untilEmit <= 0
+			if !pastDue {
+				logger.Log(levelDebug, fmt.Sprintf("%v timer set for %v in %v\n", c.name, emitTime.UTC(), untilEmit),
+					fields{"feed": c.name, "event": "timer_set", "scheduled": emitTime.UTC(), "wait": untilEmit})
+				timer.Reset(untilEmit)
+				select {
+				case <-ctx.Done():
+					cancelFeeds(q)
+					return
+				case <-sched.pause.pausing():
+					// Hold, then re-arm for the record's time moved by the pause
+					if !timer.Stop() {
+						<-timer.C()
+					}
+					continue
+				case <-timer.C():
+				}
+				fired := sched.clock.Now().UTC()
+				logger.Log(levelDebug, fmt.Sprintf("%v timer fired at %v\n", c.name, fired),
+					fields{"feed": c.name, "event": "timer_fired", "scheduled": emitTime.UTC(), "fired": fired})
+			}
+			if !c.guard(func() bool { return c.emit(emitTime, pastDue) }) {
+				heap.Pop(q)
+				continue
+			}
+		}
+		if c.guard(c.advance) {
+			(*q)[0].at = c.due
+			heap.Fix(q, 0)
+		} else {
+			heap.Pop(q)
+		}
+	}
+}
+
+// cancelFeeds logs that each feed left in q was cancelled.
+func cancelFeeds(q *emitterQueue) {
+	for _, qe := range *q {
+		logger.Detailf("%v cancelled\n", qe.e.Name())
+	}
+}
+
+// feedCursor tracks one feed's progress through a replay.
+type feedCursor struct {
+	e          feeds.Emitter
+	name       string
+	sched      replaySchedule // with the feed's own warp
+	state      *feedState
+	rng        *rand.Rand // jitter source, nil for no jitter
+	due        time.Time  // when the current record is due, before pauses
+	prevDue    time.Time  // when the previous record was due, before pauses
+	pastDueRun int        // past-due records emitted in the current catch-up run
+}
+
+func newFeedCursor(e feeds.Emitter, sched replaySchedule, warp warpSchedule, state *feedState) *feedCursor {
+	c := &feedCursor{e: e, name: e.Name(), sched: sched, state: state}
+	c.sched.warp = warp
+	if sched.jitter > 0 {
+		// One source per feed so a feed's jitter doesn't depend on the others'
+		h := fnv.New64a()
+		h.Write([]byte(c.name))
+		c.rng = rand.New(rand.NewSource(sched.seed ^ int64(h.Sum64())))
+	}
+	return c
+}
+
+// guard runs step, a call into c's feed, and reports whether it returned
+// normally. A panic stops only this feed; it's logged as an error and the
+// other feeds carry on.
+func (c *feedCursor) guard(step func() bool) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			// Only feedState is read here, the emitter may be what's broken
+			c.state.failed()
+			last, _ := c.state.get()
+			after := "before its first record"
+			if !last.IsZero() {
+				after = fmt.Sprintf("after the record at %v", last.UTC())
+			}
+			logger.Log(levelError, fmt.Sprintf("%v panicked %v and stopped: %v\n%s", c.name, after, r, debug.Stack()),
+				fields{"feed": c.name, "event": "panic", "panic": fmt.Sprint(r), "last": last.UTC()})
+			ok = false
+		}
+	}()
+	return step()
+}
+
+// advance moves the feed to its next record to replay and sets when it's
+// due. It reports false when the feed has no records left before the end of
+// the replay.
+func (c *feedCursor) advance() bool {
+	for c.e.Next() {
+		t := c.e.Time()
+		beforeStart := t.Before(c.sched.cruiseStart)
+		if beforeStart && !c.sched.catchUp {
+			continue
+		}
+		if !c.sched.cruiseEnd.IsZero() && t.After(c.sched.cruiseEnd) {
+			logger.Detailf("%v reached end of replay\n", c.name)
+			return false
+		}
+		if beforeStart {
+			// Already past due, emit now to prime the output before --start
+			c.due = c.sched.clock.Now().Add(-c.sched.pauseShift())
+		} else {
+			due, err := c.sched.scheduleTime(t)
+			if err != nil {
+				// Skip the record rather than stop every feed
+				c.state.warned()
+				logger.Log(levelWarn, fmt.Sprintf("%v: skipping record: %v\n", c.name, err), fields{"feed": c.name})
+				continue
+			}
+			if c.rng != nil {
+				due = due.Add(time.Duration(c.rng.Int63n(2*int64(c.sched.jitter)+1)) - c.sched.jitter)
+				// Never reorder a feed's records
+				if due.Before(c.prevDue) {
+					due = c.prevDue
+				}
+			}
+			c.due = due
+		}
+		c.prevDue = c.due
+		return true
+	}
+	return false
+}
+
+// emit emits the feed's current record, which was scheduled for emitTime, and
+// records the outcome and lag. pastDue records of a catch-up run don't count
+// toward lag.
+func (c *feedCursor) emit(emitTime time.Time, pastDue bool) bool {
+	if pastDue {
+		// Emitted without a timer or per-record logging until records are
+		// due in the future
+		if c.pastDueRun == 0 {
+			logger.Printf("%v catching up on past-due records\n", c.name)
+		}
+		c.pastDueRun++
+	} else if c.pastDueRun > 0 {
+		logger.Printf("%v caught up after %d past-due records\n", c.name, c.pastDueRun)
+		c.pastDueRun = 0
+	}
+
+	err := c.e.Emit()
+	c.state.wrote(c.e.Stats().Bytes, c.sched.clock.Now())
+	var w feeds.Warning
+	if errors.As(err, &w) {
+		c.state.warned()
+		logger.Log(levelWarn, fmt.Sprintf("%v\n", err), fields{"feed": c.name})
+	} else if err != nil {
+		c.state.failed()
+		logger.Log(levelError, fmt.Sprintf("%v\n", err), fields{"feed": c.name})
+	} else {
+		c.state.succeeded()
+	}
+	c.state.emitted(c.e.Time())
+	if pastDue {
+		// Lag is expected while catching up, don't count it
+		return true
+	}
+	lag := c.sched.clock.Now().Sub(emitTime)
+	fellBehind, caughtUp := c.state.finished(lag, c.sched.lagWarn)
+	if fellBehind {
+		logger.Log(levelWarn, fmt.Sprintf("%v is %v behind schedule\n", c.name, lag),
+			fields{"feed": c.name, "event": "behind", "lag": lag.String()})
+	} else if caughtUp {
+		logger.Log(levelInfo, fmt.Sprintf("%v caught up, %v behind schedule\n", c.name, lag),
+			fields{"feed": c.name, "event": "caught_up", "lag": lag.String()})
+	}
+	return true
+}
+
+// emitterQueue is a min-heap of emitters ordered by a time for each, such as
+// its current record's cruise time or when that record is due. Ties go to the
+// emitter that was listed first so output is stable.
+type emitterQueue []queuedEmitter
+
+type queuedEmitter struct {
+	e     feeds.Emitter
+	order int       // position on the command line, for ties
+	at    time.Time // time the queue is ordered by
+}
+
+func (q emitterQueue) Len() int { return len(q) }
+
+func (q emitterQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].order < q[j].order
+}
+
+func (q emitterQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *emitterQueue) Push(x interface{}) {
+	*q = append(*q, x.(queuedEmitter))
+}
+
+func (q *emitterQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
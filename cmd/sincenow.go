@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// sinceNowShift reads the feeds once, quietly and without opening network or
+// serial destinations, and returns the --shift-time that moves their first
+// record to lead from now, to the whole second.
+func sinceNowShift(feedOpts feeds.Options, lead time.Duration) time.Duration {
+	level, warningsFile := logger.level, warningsFileFlag
+	logger.level = levelError // warnings are logged when the feeds are read for real
+	warningsFileFlag = ""
+	probe := loadEmitters(feedOpts, true)
+	first := minTime(probe)
+	logger.level, warningsFileFlag = level, warningsFile
+
+	for _, e := range probe {
+		e.Close()
+	}
+	if first.IsZero() {
+		logger.Fatalf("error: --since-now: no records to replay\n")
+	}
+	return time.Now().Add(lead).Sub(first).Round(time.Second)
+}
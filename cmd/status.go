@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// feedState is the live replay state of one feed. It's updated by the replay
+// scheduler and read by status reporting.
+type feedState struct {
+	mu       sync.Mutex
+	current  time.Time     // cruise time of the last emitted record
+	next     time.Time     // wall-clock time the next record is scheduled for
+	lags     int           // number of emits measured in lagTotal
+	lagTotal time.Duration // sum of emit latencies past schedule
+	lagMax   time.Duration // largest emit latency past schedule
+	behind   bool          // last emit was later than the lag warning threshold
+	warnings int           // records skipped with a warning during replay
+	sent     int           // records emitted without an error or warning
+	errors   int           // records whose emit failed
+	bytes    int64         // bytes the feed had written or sent at the last emit
+	secStart time.Time     // start of the wall-clock second secBytes covers
+	secBytes int64         // bytes written or sent during that second
+	peak     int64         // most bytes written or sent in any one second
+}
+
+func (fs *feedState) scheduled(t time.Time) {
+	fs.mu.Lock()
+	fs.next = t
+	fs.mu.Unlock()
+}
+
+func (fs *feedState) emitted(t time.Time) {
+	fs.mu.Lock()
+	fs.current = t
+	fs.mu.Unlock()
+}
+
+// finished records that a record finished emitting lag after it was scheduled.
+// It reports whether the feed has just fallen behind by
+// more than threshold, or has just caught up again.
+func (fs *feedState) finished(lag, threshold time.Duration) (fellBehind, caughtUp bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.lags++
+	fs.lagTotal += lag
+	if lag > fs.lagMax {
+		fs.lagMax = lag
+	}
+	behind := lag > threshold
+	fellBehind = behind && !fs.behind
+	caughtUp = !behind && fs.behind
+	fs.behind = behind
+	return
+}
+
+// lag returns the average and maximum emit latency past schedule.
+func (fs *feedState) lag() (avg, max time.Duration) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.lags > 0 {
+		avg = fs.lagTotal / time.Duration(fs.lags)
+	}
+	return avg, fs.lagMax
+}
+
+// wrote records that the feed has written or sent total bytes as of now,
+// updating the peak bytes per wall-clock second.
+func (fs *feedState) wrote(total int64, now time.Time) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	sec := now.Truncate(time.Second)
+	if !sec.Equal(fs.secStart) {
+		fs.secStart = sec
+		fs.secBytes = 0
+	}
+	fs.secBytes += total - fs.bytes
+	fs.bytes = total
+	if fs.secBytes > fs.peak {
+		fs.peak = fs.secBytes
+	}
+}
+
+// peakRate returns the most bytes written or sent in any one second.
+func (fs *feedState) peakRate() int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.peak
+}
+
+func (fs *feedState) warned() {
+	fs.mu.Lock()
+	fs.warnings++
+	fs.mu.Unlock()
+}
+
+func (fs *feedState) succeeded() {
+	fs.mu.Lock()
+	fs.sent++
+	fs.mu.Unlock()
+}
+
+func (fs *feedState) failed() {
+	fs.mu.Lock()
+	fs.errors++
+	fs.mu.Unlock()
+}
+
+// counts returns the number of records emitted, skipped with a warning, and
+// failed so far, over every pass.
+func (fs *feedState) counts() (sent, warnings, errors int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.sent, fs.warnings, fs.errors
+}
+
+func (fs *feedState) warningCount() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.warnings
+}
+
+func (fs *feedState) get() (current, next time.Time) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.current, fs.next
+}
+
+// replayETA is the projected wall-clock finish of the current replay pass.
+// It's set at the start of each pass and read by status reporting.
+type replayETA struct {
+	mu     sync.Mutex
+	finish time.Time // zero if unknown, e.g. for a dry run
+}
+
+func (eta *replayETA) set(t time.Time) {
+	eta.mu.Lock()
+	eta.finish = t
+	eta.mu.Unlock()
+}
+
+func (eta *replayETA) get() time.Time {
+	eta.mu.Lock()
+	defer eta.mu.Unlock()
+	return eta.finish
+}
+
+// logETA logs the projected finish time and how long remains, if known.
+func logETA(eta *replayETA) {
+	finish := eta.get()
+	if finish.IsZero() {
+		return
+	}
+	remaining := time.Until(finish).Round(time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	logger.Log(levelInfo, fmt.Sprintf("projected finish = %v (%v remaining)\n", finish.UTC(), remaining),
+		fields{"event": "eta", "finish": finish.UTC(), "remaining": remaining.String()})
+}
+
+// feedStatus is a snapshot of a feed's replay state.
+type feedStatus struct {
+	Name     string    `json:"name"`
+	Earliest time.Time `json:"earliest"`
+	Current  time.Time `json:"current"`
+	Next     time.Time `json:"next"`
+	Done     int       `json:"done"`
+	Total    int       `json:"total"`
+	Warnings int       `json:"warnings"`
+	LagAvg   string    `json:"lag_avg"`
+	LagMax   string    `json:"lag_max"`
+	Bytes    int64     `json:"bytes"`
+	PeakBPS  int64     `json:"peak_bytes_per_sec"`
+}
+
+// replayStatus returns a snapshot of every feed. states is parallel to es.
+func replayStatus(es []feeds.Emitter, states []*feedState) []feedStatus {
+	statuses := make([]feedStatus, len(es))
+	for i, e := range es {
+		current, next := states[i].get()
+		st := e.Stats()
+		lagAvg, lagMax := states[i].lag()
+		statuses[i] = feedStatus{
+			Name:     e.Name(),
+			Earliest: st.Earliest,
+			Current:  current,
+			Next:     next,
+			Done:     st.Emitted,
+			Total:    st.Len,
+			Warnings: st.Warnings + states[i].warningCount(),
+			LagAvg:   lagAvg.String(),
+			LagMax:   lagMax.String(),
+			Bytes:    st.Bytes,
+			PeakBPS:  states[i].peakRate(),
+		}
+	}
+	return statuses
+}
+
+// serveStatus serves replay status as JSON on addr until ctx is cancelled.
+func serveStatus(ctx context.Context, addr string, es []feeds.Emitter, states []*feedState, eta *replayETA) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		var finish *time.Time
+		if t := eta.get(); !t.IsZero() {
+			finish = &t
+		}
+		if err := enc.Encode(struct {
+			Finish *time.Time   `json:"finish,omitempty"`
+			Feeds  []feedStatus `json:"feeds"`
+		}{finish, replayStatus(es, states)}); err != nil {
+			logger.Errorf("status: %v\n", err)
+		}
+	})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("status: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var limitFlag int
+
+// timelineCmd prints every record of every feed in emit order
+var timelineCmd = &cobra.Command{
+	Use:   "timeline",
+	Short: "Print the merged emit timeline of all feeds",
+	Long: `Timeline reads every feed given by the replay flags and prints one line per
+record, sorted by cruise time across all feeds, with the record's feed and
+where it would be emitted. Use --filter-from and --filter-to to limit the
+window and --limit to cap the number of lines. Nothing is sent or written.`,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogger()
+		feedOpts := parseFeedOptions()
+		emitters := loadEmitters(feedOpts, true)
+		if err := checkNames(emitters); err != nil {
+			logger.Fatalf("error: %v\n", err)
+		}
+
+		fmt.Printf("time\tfeed\ttarget\n")
+		q := &emitterQueue{}
+		for i, e := range emitters {
+			if e.Next() {
+				heap.Push(q, queuedEmitter{e: e, order: i, at: e.Time()})
+			}
+		}
+		for n := 0; q.Len() > 0 && (limitFlag <= 0 || n < limitFlag); n++ {
+			e := (*q)[0].e
+			fmt.Printf("%v\t%v\t%v\n", formatTime(e.Time()), e.Name(), e.Target())
+			if e.Next() {
+				(*q)[0].at = e.Time()
+				heap.Fix(q, 0)
+			} else {
+				heap.Pop(q)
+			}
+		}
+		for _, e := range emitters {
+			e.Close()
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(timelineCmd)
+
+	timelineCmd.Flags().IntVar(&limitFlag, "limit", 0, "print at most N records, 0 for all")
+}
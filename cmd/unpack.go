@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// bundleConfigName is the config file unpack writes for replaying a bundle.
+const bundleConfigName = "replay.yaml"
+
+// unpackCmd extracts a bundle written by pack and writes a config to replay it
+var unpackCmd = &cobra.Command{
+	Use:   "unpack bundle.tar.gz dir",
+	Short: "Unpack a bundle written by pack for replaying",
+	Long: `Unpack extracts a bundle written by cruisereplay pack into a new directory,
+checks every file against the bundle's manifest, and writes replay.yaml there
+with the bundle's input and reading flags. Replay it with
+cruisereplay --config dir/replay.yaml and any output, destination, and timing
+flags.`,
+	Args: cobra.ExactArgs(2),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogger()
+		dir, err := filepath.Abs(args[1])
+		if err != nil {
+			logger.Fatalf("error: unpack: %v\n", err)
+		}
+		if strings.ContainsAny(dir, ":,") {
+			// Either would split the input flag values written to the config
+			logger.Fatalf("error: unpack: directory %s can't contain a colon or comma\n", dir)
+		}
+		if _, err := os.Stat(dir); err == nil {
+			logger.Fatalf("error: unpack: %s already exists\n", dir)
+		}
+		m, err := unpackBundle(args[0], dir)
+		if err != nil {
+			os.RemoveAll(dir) // created by unpackBundle, don't leave a partial bundle behind
+			logger.Fatalf("error: unpack: %v\n", err)
+		}
+		configPath := filepath.Join(dir, bundleConfigName)
+		if err := writeBundleConfig(configPath, dir, m); err != nil {
+			logger.Fatalf("error: unpack: %v\n", err)
+		}
+		for _, f := range m.Feeds {
+			logger.Detailf("%s: %d records, %s to %s\n", f.Name, f.Records, f.Earliest, f.Latest)
+		}
+		logger.Printf("unpacked %d files of %d feeds to %s, replay with --config %s\n", len(m.Files), len(m.Feeds), dir, configPath)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unpackCmd)
+}
+
+// unpackBundle extracts the bundle at bundlePath into dir and returns its
+// manifest, after checking that every file it lists was extracted with the
+// right size and checksum.
+func unpackBundle(bundlePath string, dir string) (m bundleManifest, err error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return m, fmt.Errorf("%s: %v", bundlePath, err)
+	}
+	tr := tar.NewReader(zr)
+
+	sums := map[string]bundleFile{}
+	var manifest []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return m, fmt.Errorf("%s: %v", bundlePath, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Name == bundleManifestName {
+			if manifest, err = io.ReadAll(tr); err != nil {
+				return m, fmt.Errorf("%s: %v", bundlePath, err)
+			}
+			continue
+		}
+		if !isBundlePath(hdr.Name) {
+			return m, fmt.Errorf("%s: bad member name %q", bundlePath, hdr.Name)
+		}
+		bf, err := extractBundleFile(tr, hdr, dir)
+		if err != nil {
+			return m, fmt.Errorf("%s: %v", bundlePath, err)
+		}
+		sums[bf.Path] = bf
+	}
+	if manifest == nil {
+		return m, fmt.Errorf("%s: no %s, not a bundle from cruisereplay pack", bundlePath, bundleManifestName)
+	}
+	if err = json.Unmarshal(manifest, &m); err != nil {
+		return m, fmt.Errorf("%s: %s: %v", bundlePath, bundleManifestName, err)
+	}
+	if m.Version != 1 {
+		return m, fmt.Errorf("%s: unsupported bundle version %d", bundlePath, m.Version)
+	}
+	for _, want := range m.Files {
+		got, ok := sums[want.Path]
+		if !ok {
+			return m, fmt.Errorf("%s: missing %s", bundlePath, want.Path)
+		}
+		if got.Size != want.Size || got.SHA256 != want.SHA256 {
+			return m, fmt.Errorf("%s: %s doesn't match its checksum in the manifest", bundlePath, want.Path)
+		}
+	}
+	return m, nil
+}
+
+// isBundlePath reports whether name is a clean relative path that stays
+// inside the directory a bundle is unpacked to.
+func isBundlePath(name string) bool {
+	return name != "" && path.Clean(name) == name && !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// extractBundleFile writes the current member of tr under dir, keeping its
+// modification time for EVT --mtime-skew checks, and returns its size and
+// checksum.
+func extractBundleFile(tr *tar.Reader, hdr *tar.Header, dir string) (bf bundleFile, err error) {
+	outPath := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+	if err = os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return bf, err
+	}
+	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return bf, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), tr)
+	if err != nil {
+		out.Close()
+		return bf, fmt.Errorf("%s: %v", hdr.Name, err)
+	}
+	if err = out.Close(); err != nil {
+		return bf, err
+	}
+	if err = os.Chtimes(outPath, hdr.ModTime, hdr.ModTime); err != nil {
+		return bf, err
+	}
+	return bundleFile{Path: hdr.Name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// writeBundleConfig writes a --config file for replaying m from dir, with its
+// input paths under dir.
+func writeBundleConfig(configPath string, dir string, m bundleManifest) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Inputs of a cruisereplay bundle packed %s\n", m.Created)
+	values := map[string][]string{}
+	var names []string
+	for _, in := range m.Inputs {
+		p, rest := inputPath(in.Value)
+		if !isBundlePath(strings.TrimSuffix(p, "/*")) {
+			return fmt.Errorf("%s: bad input path %q", bundleManifestName, p)
+		}
+		if _, ok := values[in.Flag]; !ok {
+			names = append(names, in.Flag)
+		}
+		values[in.Flag] = append(values[in.Flag], filepath.Join(dir, filepath.FromSlash(p))+rest)
+	}
+	for _, name := range names {
+		switch name {
+		case "generic", "glob-feed":
+			writeConfigList(&b, name, values[name])
+		default:
+			// The rest take one value, a comma-separated list for underway
+			fmt.Fprintf(&b, "%s: %s\n", name, strconv.Quote(strings.Join(values[name], ",")))
+		}
+	}
+	for _, name := range bundleFlags {
+		if vals, ok := m.Flags[name]; ok {
+			writeConfigList(&b, name, vals)
+		}
+	}
+	return os.WriteFile(configPath, b.Bytes(), 0644)
+}
+
+// writeConfigList writes a config line setting name to vals, as a list if
+// there's more than one value.
+func writeConfigList(b *bytes.Buffer, name string, vals []string) {
+	quoted := make([]string, len(vals))
+	for i, v := range vals {
+		quoted[i] = strconv.Quote(v)
+	}
+	if len(quoted) == 1 {
+		fmt.Fprintf(b, "%s: %s\n", name, quoted[0])
+		return
+	}
+	fmt.Fprintf(b, "%s: [%s]\n", name, strings.Join(quoted, ", "))
+}
+
+// inputPath splits a bundle input value into its path and the rest of the
+// value after it, starting with a colon, if any.
+func inputPath(value string) (p string, rest string) {
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		return value[:i], value[i:]
+	}
+	return value, ""
+}
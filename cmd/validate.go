@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+	"github.com/spf13/cobra"
+)
+
+var strictFlag bool
+
+// validateCmd reads every requested feed and reports on it without replaying
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check replay inputs without replaying them",
+	Long: `Validate reads every feed given by the replay flags and prints its record
+count, time range, and warnings. Nothing is scheduled, sent, or written.`,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogger()
+		feedOpts := parseFeedOptions()
+		emitters := loadEmitters(feedOpts, true)
+		if err := checkNames(emitters); err != nil {
+			logger.Fatalf("error: %v\n", err)
+		}
+		if reportGapsFlag > 0 {
+			reportGaps(emitters, reportGapsFlag)
+		}
+
+		warnings := checkOverlap(emitters)
+		empty := 0
+		fmt.Printf("feed\trecords\tearliest\tlatest\twarnings\n")
+		for _, e := range emitters {
+			st := e.Stats()
+			if st.Len == 0 {
+				empty++
+			}
+			n := st.Warnings
+			if v, ok := e.(validator); ok {
+				// Skip issues already counted as warnings when the feed was read
+				warned := map[string]bool{}
+				for _, w := range e.Warnings() {
+					warned[w.Kind()+" "+w.File()] = true
+				}
+				for _, w := range v.Validate() {
+					if warned[w.Kind()+" "+w.File()] {
+						continue
+					}
+					logger.Warnf("%v\n", w)
+					n++
+				}
+			}
+			warnings += n
+			fmt.Printf("%v\t%d\t%v\t%v\t%d\n", e.Name(), st.Len, formatTime(st.Earliest), formatTime(st.Latest), n)
+			e.Close()
+		}
+		if strictFlag && warnings > 0 {
+			logger.Errorf("%d warnings\n", warnings)
+			os.Exit(1)
+		}
+		if requireNonemptyFlag && empty > 0 {
+			logger.Errorf("%d feeds have no records\n", empty)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().BoolVar(&strictFlag, "strict", false, "exit with an error if any feed has warnings")
+}
+
+// validator is a feed with extra consistency checks for validate.
+type validator interface {
+	Validate() []feeds.Warning
+}
+
+// formatTime formats t as RFC3339, or "-" if it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/armbrustlab/cruisereplay/feeds"
+)
+
+// warningRecord is one feed warning in the --warnings-file output.
+type warningRecord struct {
+	Feed    string `json:"feed"`
+	Kind    string `json:"kind"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// writeWarnings writes the warnings every emitter collected while reading its
+// input to path as a JSON array, replacing any previous file. An empty array
+// is written if there were none.
+func writeWarnings(path string, es []feeds.Emitter) (err error) {
+	records := []warningRecord{}
+	for _, e := range es {
+		for _, w := range e.Warnings() {
+			feed := w.Feed()
+			if feed == "" {
+				feed = e.Name()
+			}
+			records = append(records, warningRecord{Feed: feed, Kind: w.Kind(), File: w.File(), Line: w.Line(), Message: w.Error()})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(records); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warpSegment is a range of cruise time, as offsets from cruise start, replayed
+// at its own warp factor.
+type warpSegment struct {
+	start time.Duration
+	end   time.Duration
+	warp  float64
+}
+
+// warpSchedule maps cruise-time offsets to replay-time offsets. Cruise time
+// inside a segment is replayed at the segment's warp factor and everything
+// else at the base factor, so gaps between segments and time after the last
+// one run at --warp. Segments can't overlap.
+type warpSchedule struct {
+	base     float64
+	segments []warpSegment // sorted by start, non-overlapping
+}
+
+// validWarp reports whether w is usable as a warp factor: positive and finite.
+// A zero factor would never replay anything past cruise start, and a negative
+// one would run time backwards.
+func validWarp(w float64) bool {
+	return w > 0 && !math.IsInf(w, 1)
+}
+
+// parseWarpSchedule parses a comma-separated list of start-end:warp segments,
+// with start and end in seconds from cruise start, e.g. 0-3600:10,3600-4000:1.
+func parseWarpSchedule(spec string, base float64) (ws warpSchedule, err error) {
+	ws.base = base
+	if !validWarp(base) {
+		return ws, fmt.Errorf("warp factor must be positive and finite, got %v", base)
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var seg warpSegment
+		colon := strings.LastIndex(item, ":")
+		dash := strings.Index(item, "-")
+		if colon < 0 || dash < 0 || dash > colon {
+			return ws, fmt.Errorf("%q is not start-end:warp", item)
+		}
+		start, err1 := strconv.ParseFloat(item[:dash], 64)
+		end, err2 := strconv.ParseFloat(item[dash+1:colon], 64)
+		seg.warp, err = strconv.ParseFloat(item[colon+1:], 64)
+		if err1 != nil || err2 != nil || err != nil {
+			return ws, fmt.Errorf("%q is not start-end:warp", item)
+		}
+		if start < 0 || end <= start {
+			return ws, fmt.Errorf("%q: end must be after start and start >= 0", item)
+		}
+		if !validWarp(seg.warp) {
+			return ws, fmt.Errorf("%q: warp factor must be positive and finite", item)
+		}
+		seg.start = time.Duration(start * float64(time.Second))
+		seg.end = time.Duration(end * float64(time.Second))
+		ws.segments = append(ws.segments, seg)
+	}
+	sort.Slice(ws.segments, func(i, j int) bool {
+		return ws.segments[i].start < ws.segments[j].start
+	})
+	for i := 1; i < len(ws.segments); i++ {
+		if ws.segments[i].start < ws.segments[i-1].end {
+			return ws, fmt.Errorf("segments starting at %v and %v overlap", ws.segments[i-1].start, ws.segments[i].start)
+		}
+	}
+	return ws, nil
+}
+
+// replayOffset returns how long after replay start the cruise-time offset d
+// is replayed. It's the sum of each stretch of cruise time up to d divided by
+// its warp factor, so it's continuous and increasing in d.
+func (ws warpSchedule) replayOffset(d time.Duration) time.Duration {
+	var replay float64 // nanoseconds
+	pos := time.Duration(0)
+	for _, seg := range ws.segments {
+		if pos >= d {
+			break
+		}
+		if seg.start > pos {
+			// Gap before this segment
+			gapEnd := minDuration(seg.start, d)
+			replay += float64(gapEnd-pos) / ws.base
+			pos = gapEnd
+		}
+		if pos < d && pos < seg.end {
+			segEnd := minDuration(seg.end, d)
+			replay += float64(segEnd-pos) / seg.warp
+			pos = segEnd
+		}
+	}
+	if pos < d {
+		replay += float64(d-pos) / ws.base
+	}
+	// A tiny warp factor can slow a long cruise past what a Duration holds,
+	// converting that is undefined
+	if replay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(replay)
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// minFinishByWarp is the slowest warp factor --finish-by will choose. A
+// deadline needing less would replay slower than real time, which is better
+// asked for explicitly with --warp.
+const minFinishByWarp = 1.0
+
+// finishByWarp returns the warp factor that replays span of cruise time in
+// remaining wall-clock time.
+func finishByWarp(span, remaining time.Duration) (float64, error) {
+	if remaining <= 0 {
+		return 0, fmt.Errorf("deadline is %v in the past", -remaining.Round(time.Second))
+	}
+	warp := float64(span) / float64(remaining)
+	if warp < minFinishByWarp {
+		return 0, fmt.Errorf("replaying %v of cruise time in %v needs warp %.3g, below the minimum of %v; use --warp instead",
+			span, remaining.Round(time.Second), warp, minFinishByWarp)
+	}
+	return warp, nil
+}
+
+// feedWarpFlags are the --<feed>-warp factors by feed name, 0 to use --warp
+// and --warp-schedule. "generic" covers every --generic feed.
+var feedWarpFlags = map[string]*float64{
+	"evt":        &evtWarpFlag,
+	"sfl":        &sflWarpFlag,
+	"opp":        &oppWarpFlag,
+	"underway":   &underwayWarpFlag,
+	"seaflowlog": &seaflowlogWarpFlag,
+	"generic":    &genericWarpFlag,
+}
+
+// feedWarp returns the warp schedule for the feed named name, a constant
+// factor if its --<feed>-warp is set and ws otherwise.
+func feedWarp(name string, ws warpSchedule) warpSchedule {
+	if strings.HasPrefix(name, "generic:") {
+		name = "generic"
+	}
+	if w, ok := feedWarpFlags[name]; ok && *w > 0 {
+		return warpSchedule{base: *w}
+	}
+	return ws
+}
+
+// checkFeedWarps returns an error if any --<feed>-warp is neither 0 nor a
+// valid warp factor, and reports whether any is set.
+func checkFeedWarps() (set bool, err error) {
+	for name, w := range feedWarpFlags {
+		if *w != 0 && !validWarp(*w) {
+			return false, fmt.Errorf("--%s-warp must be positive and finite, or 0 to use --warp, got %v", name, *w)
+		}
+		if *w > 0 {
+			set = true
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidWarp(t *testing.T) {
+	tests := []struct {
+		warp float64
+		want bool
+	}{
+		{1, true},
+		{0.001, true},
+		{1e6, true},
+		{0, false},
+		{-1, false},
+		{math.NaN(), false},
+		{math.Inf(1), false},
+		{math.Inf(-1), false},
+	}
+	for _, tt := range tests {
+		if got := validWarp(tt.warp); got != tt.want {
+			t.Errorf("validWarp(%v) = %v, want %v", tt.warp, got, tt.want)
+		}
+	}
+}
+
+func TestParseWarpScheduleRejectsBadWarps(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		base float64
+	}{
+		{"zero base", "", 0},
+		{"negative base", "", -2},
+		{"NaN base", "", math.NaN()},
+		{"infinite base", "", math.Inf(1)},
+		{"negative infinite base", "", math.Inf(-1)},
+		{"zero segment", "0-10:0", 1},
+		{"negative segment", "0-10:-5", 1},
+		{"NaN segment", "0-10:NaN", 1},
+		{"infinite segment", "0-10:Inf", 1},
+		{"negative infinite segment", "0-10:-Inf", 1},
+		{"infinite later segment", "0-10:2,10-20:+Inf", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseWarpSchedule(tt.spec, tt.base); err == nil {
+				t.Errorf("parseWarpSchedule(%q, %v) accepted it", tt.spec, tt.base)
+			}
+		})
+	}
+	if _, err := parseWarpSchedule("0-10:2,10-20:0.5", 1); err != nil {
+		t.Errorf("valid schedule rejected: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package feeds
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// CopyLimiter bounds how many output file copies run at once across feeds, so
+// a burst of EVT and OPP files coming due together queues rather than
+// saturating the disk. A nil CopyLimiter doesn't limit anything.
+type CopyLimiter struct {
+	slots chan struct{}
+}
+
+// NewCopyLimiter creates a CopyLimiter allowing n copies at once.
+func NewCopyLimiter(n int) *CopyLimiter {
+	return &CopyLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire blocks until a copy can start.
+func (l *CopyLimiter) acquire() {
+	if l != nil {
+		l.slots <- struct{}{}
+	}
+}
+
+// release marks a copy started with acquire as finished.
+func (l *CopyLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// copyJob copies a file to a temporary path and returns a function that puts
+// it in place at its output path.
+type copyJob func() (place func() error, err error)
+
+// copyQueue runs the copies of one feed. With a CopyLimiter, a copy runs in
+// the background once a slot is free and Emit moves on, so a burst of records
+// queues behind the limit. Copied files are still put in place in emit order.
+type copyQueue struct {
+	last chan struct{} // closed once the last queued copy is in place, nil for none
+	mu   sync.Mutex
+	errs []error // errors of background copies not yet returned
+}
+
+// run runs job. With a nil l, or if background is false, it waits for earlier
+// copies, runs job, and returns its error. Otherwise it only blocks until l
+// has a free slot, runs job in the background, and returns the error of an
+// earlier background copy not returned yet.
+func (q *copyQueue) run(l *CopyLimiter, background bool, job copyJob) error {
+	if l == nil || !background {
+		q.wait()
+		l.acquire()
+		place, err := job()
+		l.release()
+		if err == nil {
+			err = place()
+		}
+		if err != nil {
+			return err
+		}
+		return q.next()
+	}
+
+	l.acquire()
+	prev, done := q.last, make(chan struct{})
+	q.last = done
+	go func() {
+		defer close(done)
+		place, err := job()
+		// Free the slot for the next copy while this one waits its turn
+		l.release()
+		if prev != nil {
+			<-prev
+		}
+		if err == nil {
+			err = place()
+		}
+		if err != nil {
+			q.mu.Lock()
+			q.errs = append(q.errs, err)
+			q.mu.Unlock()
+		}
+	}()
+	return q.next()
+}
+
+// next returns the oldest background copy error not returned yet, or nil.
+func (q *copyQueue) next() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.errs) == 0 {
+		return nil
+	}
+	err := q.errs[0]
+	q.errs = q.errs[1:]
+	return err
+}
+
+// wait blocks until every queued copy is in place.
+func (q *copyQueue) wait() {
+	if q.last != nil {
+		<-q.last
+		q.last = nil
+	}
+}
+
+// flush waits for the queued copies and returns every error not returned
+// yet, in one error.
+func (q *copyQueue) flush() error {
+	q.wait()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer func() { q.errs = nil }()
+	switch len(q.errs) {
+	case 0:
+		return nil
+	case 1:
+		return q.errs[0]
+	}
+	msgs := make([]string, len(q.errs))
+	for i, err := range q.errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
@@ -0,0 +1,27 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package feeds
+
+import (
+	"os"
+	"syscall"
+)
+
+// sameDevice reports whether paths a and b are on the same filesystem.
+func sameDevice(a string, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	as, aok := ai.Sys().(*syscall.Stat_t)
+	bs, bok := bi.Sys().(*syscall.Stat_t)
+	if !aok || !bok {
+		return false, nil
+	}
+	return as.Dev == bs.Dev, nil
+}
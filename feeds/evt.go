@@ -1,18 +1,40 @@
 package feeds
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// evtPattern matches an uncompressed EVT file name
+const evtPattern = "????-??-??T??-??-??[\\-\\+]??-??"
+
+// isEVTName reports whether a file name, without directories, is that of an
+// EVT file, optionally gzipped.
+func isEVTName(name string) bool {
+	found, matchErr := filepath.Match(evtPattern, name)
+	if matchErr != nil {
+		panic(matchErr)
+	}
+	if !found {
+		found, matchErr = filepath.Match(evtPattern+".gz", name)
+		if matchErr != nil {
+			panic(matchErr)
+		}
+	}
+	return found
+}
+
 func FindEVTFiles(dir string) (files []string, err error) {
-	pattern := "????-??-??T??-??-??[\\-\\+]??-??"
-	patterngz := pattern + ".gz"
 
 	err = filepath.WalkDir(dir, func(walkPath string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
@@ -24,24 +46,8 @@ func FindEVTFiles(dir string) (files []string, err error) {
 			fmt.Fprintf(os.Stderr, "error: %v\n", walkErr)
 			return nil
 		}
-		if !d.IsDir() {
-			// Look for uncompressed EVT files
-			found, matchErr := filepath.Match(pattern, d.Name())
-			if matchErr != nil {
-				panic(matchErr)
-			}
-			if found {
-				files = append(files, walkPath)
-			} else {
-				// Look for uncompressed EVT files
-				found, matchErr = filepath.Match(patterngz, d.Name())
-				if matchErr != nil {
-					panic(matchErr)
-				}
-				if found {
-					files = append(files, walkPath)
-				}
-			}
+		if !d.IsDir() && isEVTName(d.Name()) {
+			files = append(files, walkPath)
 		}
 		return nil
 	})
@@ -50,33 +56,162 @@ func FindEVTFiles(dir string) (files []string, err error) {
 
 type Evt struct {
 	i        int // index of next item to emit
+	progress progress
+	output   byteCount // bytes written or sent
 	data     []evtFile
 	outDir   string
+	archive  *tarSource // tar archive the files are read from, nil for plain files
+	copies   copyQueue  // copies running in the background under opts.Copies
+	found    []string   // every input file in the order it was found, for Validate
+	opts     EvtOptions
 	warnings []Warning
 }
 
-func NewEvt(files []string, outDir string) (e *Evt, err error) {
-	e = &Evt{i: -1}
+// EvtOptions configures an Evt feed.
+type EvtOptions struct {
+	Options
+	Link      string        // LinkCopy, LinkHard, or LinkSymbolic, "" to copy
+	MtimeSkew time.Duration // warn if a file's mtime is further than this from its name, 0 to skip
+	Sample    int           // keep only every Sample'th file, for testing, 0 or 1 for all
+	NoOutput  bool          // schedule files without writing them, for timing tests of other feeds
+}
+
+// How EVT files are placed in the output directory
+const (
+	LinkCopy     = "copy"     // copy the file, decompressing gzipped files
+	LinkHard     = "hardlink" // hard link to the source file
+	LinkSymbolic = "symlink"  // symlink to the source file's absolute path
+)
+
+// NewEvt creates an EVT feed from files, keeping those inside the time window
+// in opts.
+func NewEvt(files []string, outDir string, opts EvtOptions) (e *Evt, err error) {
+	e = &Evt{i: -1, opts: opts}
 	e.data = []evtFile{}
 	e.outDir = outDir
+	switch opts.Link {
+	case "", LinkCopy, LinkHard, LinkSymbolic:
+	default:
+		return e, fmt.Errorf("evt: unknown link mode %q, choose from %q", opts.Link, []string{LinkCopy, LinkHard, LinkSymbolic})
+	}
+	e.found = append(e.found, files...)
 	for _, f := range files {
-		t, err := timeFromFilename(f)
+		t, err := timeFromFilename(f, opts.TZ)
 		if err != nil {
-			e.warnings = append(e.warnings, Warning{err: fmt.Errorf("evt: bad timestamp in %s: %v", f, err)})
+			// Skip it rather than replay it at the zero time before everything else
+			e.warnings = append(e.warnings, Warning{err: fmt.Errorf("evt: skipping %s, bad timestamp: %v", f, err), feed: "evt", kind: WarnTimestamp, file: f})
+			if err := opts.checkWarnings(e.warnings); err != nil {
+				return e, err
+			}
+			continue
 		}
+		if !opts.keep(opts.shift(t)) {
+			continue
+		}
+		if opts.MtimeSkew > 0 {
+			// Against the unshifted time the file was written at
+			fi, err := os.Stat(f)
+			if err != nil {
+				e.warnings = append(e.warnings, Warning{err: fmt.Errorf("evt: %v", err), feed: "evt", kind: WarnIO, file: f})
+			} else if w, ok := checkMtime(f, fi.ModTime(), t, opts.MtimeSkew); !ok {
+				e.warnings = append(e.warnings, w)
+			}
+			if err := opts.checkWarnings(e.warnings); err != nil {
+				return e, err
+			}
+		}
+		t = opts.shift(t)
 		ef := evtFile{path: f, time: t}
 		e.data = append(e.data, ef)
 	}
 
-	// Sort by time, ascending
+	// Sort by time, ascending, then by path so ties replay in the same order
+	// regardless of input order
 	sort.SliceStable(e.data, func(i, j int) bool {
-		return e.data[i].time.Before(e.data[j].time)
+		if !e.data[i].time.Equal(e.data[j].time) {
+			return e.data[i].time.Before(e.data[j].time)
+		}
+		return e.data[i].path < e.data[j].path
 	})
+	e.sample()
 
 	return e, nil
 }
 
+// sample keeps every opts.Sample'th file of the sorted files, starting with
+// the first.
+func (e *Evt) sample() {
+	if e.opts.Sample <= 1 {
+		return
+	}
+	kept := e.data[:0]
+	for i := 0; i < len(e.data); i += e.opts.Sample {
+		kept = append(kept, e.data[i])
+	}
+	e.data = kept
+}
+
+// Validate checks the EVT files in the order they were found, before sorting,
+// for names without a timestamp, zero times, files out of time order, and
+// files with the same timestamp, such as copies in two directories.
+func (e *Evt) Validate() (issues []Warning) {
+	var prev evtFile // the latest file found so far
+	var found []evtFile
+	for _, f := range e.found {
+		t, err := timeFromFilename(f, e.opts.TZ)
+		if err != nil {
+			issues = append(issues, Warning{err: fmt.Errorf("evt: %s has no valid timestamp: %v", f, err), feed: "evt", kind: WarnTimestamp, file: f})
+			continue
+		}
+		if t.IsZero() {
+			issues = append(issues, Warning{err: fmt.Errorf("evt: %s has a zero timestamp", f), feed: "evt", kind: WarnTimestamp, file: f})
+			continue
+		}
+		if t.Before(prev.time) {
+			issues = append(issues, Warning{err: fmt.Errorf("evt: %s is out of order, it's before %s found ahead of it", f, prev.path), feed: "evt", kind: WarnTimestamp, file: f})
+		} else {
+			prev = evtFile{time: t, path: f}
+		}
+		found = append(found, evtFile{time: t, path: f})
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].time.Before(found[j].time) })
+	for i := 1; i < len(found); i++ {
+		if found[i].time.Equal(found[i-1].time) {
+			issues = append(issues, Warning{err: fmt.Errorf("evt: %s has the same timestamp as %s", found[i].path, found[i-1].path), feed: "evt", kind: WarnTimestamp, file: found[i].path})
+		}
+	}
+	return issues
+}
+
+// checkMtime compares mtime, the modification time of file, to t, the time in
+// its name, returning a Warning if they're more than tolerance apart.
+func checkMtime(file string, mtime time.Time, t time.Time, tolerance time.Duration) (Warning, bool) {
+	skew := mtime.Sub(t)
+	if skew > tolerance || skew < -tolerance {
+		err := fmt.Errorf("evt: %s was modified at %v, %v from its filename timestamp", file, mtime.UTC(), skew.Round(time.Second))
+		return Warning{err: err, feed: "evt", kind: WarnTimestamp, file: file}, false
+	}
+	return Warning{}, true
+}
+
+// Close waits for any copies still running and returns their errors.
 func (e *Evt) Close() (err error) {
+	if err = e.copies.flush(); err != nil {
+		return err
+	}
+	if e.archive != nil {
+		if err = e.archive.Close(); err != nil {
+			return fmt.Errorf("evt: %v", err)
+		}
+	}
+	return
+}
+
+func (e *Evt) Reset() (err error) {
+	// Errors of the last pass's copies are returned by the next Emit or Close
+	e.copies.wait()
+	e.i = -1
+	e.progress.set(0)
 	return
 }
 
@@ -87,34 +222,195 @@ func (e *Evt) Earliest() (t time.Time) {
 	return
 }
 
+func (e *Evt) Latest() (t time.Time) {
+	if len(e.data) > 0 {
+		t = e.data[len(e.data)-1].time
+	}
+	return
+}
+
+// Emit copies or links the current EVT file into the output directory. With
+// opts.Copies set, a copy of a plain file runs in the background and any error
+// is returned by a later Emit or by Close.
 func (e *Evt) Emit() (err error) {
-	if e.i < 0 {
+	if e.i < 0 || e.opts.NoOutput {
 		return
 	}
-	doyDir := fmt.Sprintf("%d_%03d", e.data[e.i].time.Year(), e.data[e.i].time.YearDay())
-	outDir := filepath.Join(e.outDir, "datafiles", "evt", doyDir)
-	if err = os.MkdirAll(outDir, os.ModePerm); err != nil {
+	outPath, err := e.outPath()
+	if err != nil {
+		return fmt.Errorf("evt: %v", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(outPath), e.opts.dirMode()); err != nil {
 		return fmt.Errorf("evt: %v", err)
 	}
-	outPath := filepath.Join(outDir, filepath.Base(e.data[e.i].path))
+	path := e.data[e.i].path
+	gzipped := strings.HasSuffix(path, ".gz")
+
+	if e.archive != nil {
+		src, err := e.archive.open(e.data[e.i].entry)
+		if err != nil {
+			return fmt.Errorf("evt: %v", err)
+		}
+		// Members are read in turn from one stream, so copy them here
+		return e.copies.run(e.opts.Copies, false, func() (func() error, error) {
+			return e.copy(src, gzipped, path, outPath)
+		})
+	}
 
-	src, err := os.Open(e.data[e.i].path)
+	src, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Moved or deleted since the feed was read, e.g. on network storage
+		return Warning{err: fmt.Errorf("evt: skipping %s, source file is gone", path), feed: "evt", kind: WarnIO, file: path}
+	}
 	if err != nil {
 		return fmt.Errorf("evt: %v", err)
 	}
-	defer src.Close()
 
-	dst, err := os.Create(outPath)
+	// Gzipped files are always copied since they have to be decompressed
+	if (e.opts.Link == LinkHard || e.opts.Link == LinkSymbolic) && !gzipped {
+		src.Close()
+		// After any queued copies, to keep output files in emit order
+		e.copies.wait()
+		linked, err := e.link(path, outPath)
+		if err != nil {
+			return fmt.Errorf("evt: %v", err)
+		}
+		if linked {
+			if e.opts.Manifest != nil {
+				if err = e.opts.Manifest.recordFile(e.Name(), outPath); err != nil {
+					return fmt.Errorf("evt: %v", err)
+				}
+			}
+			return e.copies.next()
+		}
+		// On another filesystem, fall back to copying
+		if src, err = os.Open(path); err != nil {
+			return fmt.Errorf("evt: %v", err)
+		}
+	}
+
+	return e.copies.run(e.opts.Copies, true, func() (func() error, error) {
+		defer src.Close()
+		return e.copy(src, gzipped, path, outPath)
+	})
+}
+
+// copy writes the EVT file at path, read from src, to a hidden temp file next
+// to outPath, decompressing it if it's gzipped. It returns a function that
+// renames the temp file into place and records it, so consumers watching the
+// directory only see complete files.
+func (e *Evt) copy(src io.Reader, gzipped bool, path string, outPath string) (place func() error, err error) {
+	var r io.Reader = src
+	if gzipped {
+		gzr, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("evt: %s: %v", path, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(outPath), "."+filepath.Base(outPath)+".tmp-")
 	if err != nil {
-		return fmt.Errorf("evt: %v", err)
+		return nil, fmt.Errorf("evt: %v", err)
+	}
+	tmpPath := dst.Name()
+	if err = dst.Chmod(e.opts.fileMode()); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("evt: %v", err)
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
-		return fmt.Errorf("evt: %v", err)
+	// Hash while copying for the manifest
+	h := sha256.New()
+	n, err := e.opts.copy(io.MultiWriter(dst, h), r)
+	if err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("evt: %s: %v", path, err)
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("evt: %v", err)
 	}
 
-	return
+	return func() error {
+		if err := os.Rename(tmpPath, outPath); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("evt: %v", err)
+		}
+		e.output.add(n)
+		if e.opts.Manifest != nil {
+			if err := e.opts.Manifest.record(e.Name(), outPath, h, n); err != nil {
+				return fmt.Errorf("evt: %v", err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// link places srcPath at outPath with a hard link or symlink, going through a
+// temporary name so an existing output file is replaced in one step. It
+// reports false without linking if srcPath and outPath's directory are on
+// different filesystems.
+func (e *Evt) link(srcPath string, outPath string) (linked bool, err error) {
+	same, err := sameDevice(srcPath, filepath.Dir(outPath))
+	if err != nil {
+		return false, err
+	}
+	if !same {
+		return false, nil
+	}
+	tmpPath := filepath.Join(filepath.Dir(outPath), "."+filepath.Base(outPath)+".tmp-link")
+	os.Remove(tmpPath)
+	if e.opts.Link == LinkHard {
+		err = os.Link(srcPath, tmpPath)
+		if errors.Is(err, syscall.EXDEV) {
+			return false, nil
+		}
+	} else {
+		var abs string
+		if abs, err = filepath.Abs(srcPath); err == nil {
+			err = os.Symlink(abs, tmpPath)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = os.Rename(tmpPath, outPath); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// outPath returns the output path for the current EVT file. Gzipped EVT files
+// are decompressed on output to match what a live instrument writes.
+func (e *Evt) outPath() (string, error) {
+	base := strings.TrimSuffix(filepath.Base(e.data[e.i].path), ".gz")
+	return e.opts.outputPath(e.outDir, "evt", filepath.Join("datafiles", "evt"), e.data[e.i].time, base)
+}
+
+func (e *Evt) Target() string {
+	if e.i < 0 {
+		return ""
+	}
+	outPath, err := e.outPath()
+	if err != nil {
+		return ""
+	}
+	return outPath
+}
+
+// Seek positions the feed so that the next call to Next moves to the first
+// record at or after t. It reports whether any such record exists.
+func (e *Evt) Seek(t time.Time) bool {
+	idx := sort.Search(len(e.data), func(i int) bool {
+		return !e.data[i].time.Before(t)
+	})
+	e.i = idx - 1
+	e.progress.set(idx)
+	return idx < len(e.data)
 }
 
 func (e *Evt) Time() (t time.Time) {
@@ -127,6 +423,7 @@ func (e *Evt) Time() (t time.Time) {
 func (e *Evt) Next() bool {
 	if e.i+1 < len(e.data) {
 		e.i++
+		e.progress.set(e.i + 1)
 		return true
 	}
 	return false
@@ -144,9 +441,26 @@ func (e *Evt) Len() int {
 	return len(e.data)
 }
 
+func (e *Evt) Times() []time.Time {
+	ts := make([]time.Time, len(e.data))
+	for i, rec := range e.data {
+		ts[i] = rec.time
+	}
+	return ts
+}
+
+func (e *Evt) Progress() (done int, total int) {
+	return e.progress.get(), len(e.data)
+}
+
+func (e *Evt) Stats() FeedStats {
+	return statsOf(e, &e.output)
+}
+
 type evtFile struct {
-	time time.Time
-	path string
+	time  time.Time
+	path  string // file path, or the member name in an archive
+	entry int    // position of the member in an archive, counting from 0
 }
 
 func (ef evtFile) String() string {
@@ -0,0 +1,291 @@
+package feeds
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeEvtFile writes data to dir/name, gzipping it if name ends in .gz, and
+// returns its path.
+func writeEvtFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	if strings.HasSuffix(name, ".gz") {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(data)
+		zw.Close()
+		data = b.Bytes()
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// listFiles returns the paths of every file under dir, relative to it.
+func listFiles(t *testing.T, dir string) (files []string) {
+	t.Helper()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestEvtTruncatedGzipLeavesNoFile(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	f := writeEvtFile(t, in, "2021-01-01T00-00-00+00-00.gz", bytes.Repeat([]byte("evt data "), 1000))
+	data, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEvt([]string{f}, out, EvtOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Next()
+	if err := e.Emit(); err == nil {
+		t.Fatal("Emit of a truncated gzip file succeeded")
+	}
+	if files := listFiles(t, out); len(files) != 0 {
+		t.Errorf("failed copy left %q", files)
+	}
+}
+
+func TestEvtBackgroundCopyErrorReportedOnce(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	bad := writeEvtFile(t, in, "2021-01-01T00-00-00+00-00.gz", bytes.Repeat([]byte("evt data "), 1000))
+	data, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	good := writeEvtFile(t, in, "2021-01-01T00-03-00+00-00", []byte("evt data"))
+	e, err := NewEvt([]string{bad, good}, out, EvtOptions{Options: Options{Copies: NewCopyLimiter(1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	for e.Next() {
+		if err := e.Emit(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), bad) {
+		t.Errorf("Emit and Close returned %v, want one error for %s", errs, bad)
+	}
+	if files := listFiles(t, out); !reflect.DeepEqual(files, []string{filepath.Join("datafiles", "evt", "2021_001", filepath.Base(good))}) {
+		t.Errorf("output files = %q, want only %s", files, filepath.Base(good))
+	}
+}
+
+func TestNewEvtSkipsBadNames(t *testing.T) {
+	dir := t.TempDir()
+	good := []string{
+		filepath.Join(dir, "2021-01-01T00-03-00+00-00.gz"),
+		filepath.Join(dir, "2021-01-01T00-00-00+00-00"),
+	}
+	bad := []string{
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(dir, "2021-13-45T99-00-00+00-00"),
+		filepath.Join(dir, "garbage.gz"),
+		filepath.Join(dir, "2021-01-01"),
+	}
+	e, err := NewEvt(append(append([]string{}, bad[:2]...), append(good, bad[2:]...)...), t.TempDir(), EvtOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Len() != len(good) {
+		t.Fatalf("kept %d files %v, want the %d with good names", e.Len(), e.data, len(good))
+	}
+	want := []time.Time{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 3, 0, 0, time.UTC)}
+	for i, ef := range e.data {
+		if !ef.time.Equal(want[i]) {
+			t.Errorf("file %d %s at %v, want %v", i, ef.path, ef.time, want[i])
+		}
+	}
+	warned := map[string]bool{}
+	for _, w := range e.Warnings() {
+		if w.Kind() != WarnTimestamp {
+			t.Errorf("warning %v is %s, want %s", w, w.Kind(), WarnTimestamp)
+		}
+		warned[w.File()] = true
+	}
+	for _, f := range bad {
+		if !warned[f] {
+			t.Errorf("no warning for %s", f)
+		}
+	}
+	// Validate checks the names as given, so it finds the skipped ones too,
+	// and the good files given out of order
+	invalid := map[string]bool{}
+	for _, w := range e.Validate() {
+		invalid[w.File()] = true
+	}
+	warned[good[1]] = true
+	if !reflect.DeepEqual(invalid, warned) {
+		t.Errorf("Validate found issues with %v, want %v", invalid, warned)
+	}
+}
+
+// validateFiles returns the messages of the issues Validate finds for an Evt
+// of files.
+func validateFiles(t *testing.T, files ...string) (issues []string) {
+	t.Helper()
+	e, err := NewEvt(files, t.TempDir(), EvtOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range e.Validate() {
+		if w.Kind() != WarnTimestamp {
+			t.Errorf("issue %v is %s, want %s", w, w.Kind(), WarnTimestamp)
+		}
+		issues = append(issues, w.String())
+	}
+	return issues
+}
+
+func TestEvtValidateOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"2021_001", "2021_002", "copy"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The copy directory is walked after the day directories, so its copy of
+	// a day 1 file is found after day 2
+	day1 := writeEvtFile(t, dir, filepath.Join("2021_001", "2021-01-01T00-00-00+00-00"), nil)
+	day2 := writeEvtFile(t, dir, filepath.Join("2021_002", "2021-01-02T00-00-00+00-00"), nil)
+	dup := writeEvtFile(t, dir, filepath.Join("copy", "2021-01-01T00-00-00+00-00.gz"), nil)
+	files, err := FindEVTFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{day1, day2, dup}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("found %q, want %q", files, want)
+	}
+	got := validateFiles(t, files...)
+	want := []string{
+		"evt: " + dup + " is out of order, it's before " + day2 + " found ahead of it",
+		"evt: " + dup + " has the same timestamp as " + day1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("issues = %q, want %q", got, want)
+	}
+
+	// A day 2 file misplaced in day 1's directory is found ahead of the rest
+	// of day 2
+	misplaced := writeEvtFile(t, dir, filepath.Join("2021_001", "2021-01-02T00-03-00+00-00"), nil)
+	later := writeEvtFile(t, dir, filepath.Join("2021_001", "2021-01-01T00-03-00+00-00"), nil)
+	if files, err = FindEVTFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	got = validateFiles(t, files...)
+	want = []string{
+		"evt: " + day2 + " is out of order, it's before " + misplaced + " found ahead of it",
+		"evt: " + dup + " is out of order, it's before " + misplaced + " found ahead of it",
+		"evt: " + dup + " has the same timestamp as " + day1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("issues = %q, want %q", got, want)
+	}
+
+	if got := validateFiles(t, day1, later, day2, misplaced); len(got) != 0 {
+		t.Errorf("files in order have issues %q", got)
+	}
+}
+
+func TestEvtValidateBadNames(t *testing.T) {
+	dir := t.TempDir()
+	good := writeEvtFile(t, dir, "2021-01-01T00-00-00+00-00", nil)
+	zero := writeEvtFile(t, dir, "0001-01-01T00-00-00+00-00", nil)
+	unparsed := writeEvtFile(t, dir, "2021-01-01T25-00-00+00-00", nil)
+	got := validateFiles(t, zero, good, unparsed)
+	if len(got) != 2 || got[0] != "evt: "+zero+" has a zero timestamp" || !strings.HasPrefix(got[1], "evt: "+unparsed+" has no valid timestamp") {
+		t.Errorf("issues = %q, want a zero timestamp for %s and no valid timestamp for %s", got, zero, unparsed)
+	}
+}
+
+func TestEvtMissingSourceWarns(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	gone := writeEvtFile(t, in, "2021-01-01T00-00-00+00-00", []byte("gone"))
+	kept := writeEvtFile(t, in, "2021-01-01T00-03-00+00-00", []byte("kept"))
+	e, err := NewEvt([]string{gone, kept}, out, EvtOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Next()
+	err = e.Emit()
+	var w Warning
+	if !errors.As(err, &w) || !strings.Contains(w.Error(), gone) {
+		t.Fatalf("Emit of a deleted source = %v, want a Warning for %s", err, gone)
+	}
+	e.Next()
+	if err := e.Emit(); err != nil {
+		t.Fatalf("Emit after the missing file: %v", err)
+	}
+	if files := listFiles(t, out); !reflect.DeepEqual(files, []string{filepath.Join("datafiles", "evt", "2021_001", filepath.Base(kept))}) {
+		t.Errorf("output files = %q, want only %s", files, filepath.Base(kept))
+	}
+}
+
+func BenchmarkEvtCopy(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4*1024*1024/16) // a 4MB EVT file
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	for _, size := range []int{0, 4 * 1024, 32 * 1024, 256 * 1024, 1024 * 1024} {
+		name := fmt.Sprintf("buffer=%d", size)
+		if size == 0 {
+			name = "buffer=default"
+		}
+		b.Run(name, func(b *testing.B) {
+			// Gzipped, since an uncompressed os.File source may be copied
+			// with its own WriteTo instead of the buffer
+			f := filepath.Join(b.TempDir(), "2021-01-01T00-00-00+00-00.gz")
+			if err := os.WriteFile(f, gz.Bytes(), 0644); err != nil {
+				b.Fatal(err)
+			}
+			e, err := NewEvt([]string{f}, b.TempDir(), EvtOptions{Options: Options{CopyBuf: size}})
+			if err != nil {
+				b.Fatal(err)
+			}
+			e.Next()
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := e.Emit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
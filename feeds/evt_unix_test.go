@@ -0,0 +1,169 @@
+//go:build !windows
+// +build !windows
+
+package feeds
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestEvtCopyHiddenUntilComplete(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	name := "2021-01-01T00-00-00+00-00"
+	// A FIFO source lets the test hold the copy half done
+	src := filepath.Join(in, name)
+	if err := syscall.Mkfifo(src, 0644); err != nil {
+		t.Skipf("can't make a FIFO: %v", err)
+	}
+	e, err := NewEvt([]string{src}, out, EvtOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Next()
+	done := make(chan error, 1)
+	go func() { done <- e.Emit() }()
+
+	w, err := os.OpenFile(src, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	first, rest := "first half ", "second half"
+	if _, err := w.WriteString(first); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the first half to be copied out
+	var files []string
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		files = listFiles(t, out)
+		if len(files) == 1 {
+			if fi, err := os.Stat(filepath.Join(out, files[0])); err == nil && fi.Size() == int64(len(first)) {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("first half not copied, output files %q", files)
+		}
+	}
+	if !strings.HasPrefix(filepath.Base(files[0]), ".") {
+		t.Errorf("partial copy is at %s, want a hidden temp file", files[0])
+	}
+
+	if _, err := w.WriteString(rest); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join("datafiles", "evt", "2021_001", name)
+	if files := listFiles(t, out); !reflect.DeepEqual(files, []string{want}) {
+		t.Fatalf("output files = %q, want only %s", files, want)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, want)); err != nil || string(b) != first+rest {
+		t.Errorf("output = %q, %v, want %q", b, err, first+rest)
+	}
+}
+
+func TestEvtCopiesQueueBehindLimit(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	// The first copy reads a FIFO so the test can hold it open while later
+	// records are emitted
+	names := []string{"2021-01-01T00-00-00+00-00", "2021-01-01T00-03-00+00-00", "2021-01-01T00-06-00+00-00",
+		"2021-01-01T00-09-00+00-00", "2021-01-01T00-12-00+00-00"}
+	fifo := filepath.Join(in, names[0])
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skipf("can't make a FIFO: %v", err)
+	}
+	files := []string{fifo}
+	for _, name := range names[1:] {
+		files = append(files, writeEvtFile(t, in, name, []byte(name)))
+	}
+	m, err := NewManifest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const limit = 2
+	e, err := NewEvt(files, out, EvtOptions{Options: Options{Copies: NewCopyLimiter(limit), Manifest: m}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := make(chan *os.File, 1)
+	go func() {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+		}
+		writer <- w
+	}()
+	emitted := make(chan error, 1)
+	go func() {
+		for e.Next() {
+			if err := e.Emit(); err != nil {
+				emitted <- err
+				return
+			}
+		}
+		emitted <- nil
+	}()
+	select {
+	case err := <-emitted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Emit blocked with the first of %d copies held open and a limit of %d", len(files), limit)
+	}
+	w := <-writer
+	if w == nil {
+		t.FailNow()
+	}
+	// Every Emit has returned, but the later copies wait for the first to be
+	// put in place
+	for _, f := range listFiles(t, out) {
+		if f != "manifest.tsv" && !strings.HasPrefix(filepath.Base(f), ".") {
+			t.Errorf("%s in place before the copy emitted ahead of it", f)
+		}
+	}
+
+	if _, err := w.WriteString(names[0]); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, name := range names {
+		want = append(want, filepath.Join("datafiles", "evt", "2021_001", name))
+	}
+	if got := listFiles(t, out); !reflect.DeepEqual(got, append(append([]string{}, want...), "manifest.tsv")) {
+		t.Fatalf("output files = %q, want %q and the manifest", got, want)
+	}
+	for _, f := range want {
+		if b, err := os.ReadFile(filepath.Join(out, f)); err != nil || string(b) != filepath.Base(f) {
+			t.Errorf("%s = %q, %v, want %q", f, b, err, filepath.Base(f))
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(out, "manifest.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n")[1:] {
+		recorded = append(recorded, strings.Split(line, "\t")[2])
+	}
+	if !reflect.DeepEqual(recorded, want) {
+		t.Errorf("manifest records %q, want them in emit order %q", recorded, want)
+	}
+}
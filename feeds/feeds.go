@@ -23,38 +23,322 @@ package feeds
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
 	"time"
 )
 
 type Emitter interface {
 	Name() string
 	Earliest() time.Time
-	Next() bool      // move to next item to emit in time series
-	Time() time.Time // get time for item to emit
+	Latest() time.Time // time of the last item, zero if there are none
+	Next() bool        // move to next item to emit in time series
+	Time() time.Time   // get time for item to emit
 	Emit() error
-	Close() error // close any open resources
+	Target() string        // describe where the current item will be emitted
+	Close() error          // close any open resources
+	Reset() error          // rewind to the first item so the feed can be replayed
+	Seek(t time.Time) bool // move so the next item is the first at or after t
 	Len() int
+	Times() []time.Time              // record times in emit order
+	Progress() (done int, total int) // items emitted or in flight, and total items
+	Warnings() []Warning             // problems found while reading the feed
+	Stats() FeedStats                // snapshot of the counts and times above
 }
 
+// FeedStats is a snapshot of a feed's size, time range, and progress.
+type FeedStats struct {
+	Earliest time.Time // time of the first item, zero if there are none
+	Latest   time.Time // time of the last item, zero if there are none
+	Len      int       // items in the feed
+	Emitted  int       // items emitted or in flight this pass
+	Warnings int       // problems found while reading the feed
+	Bytes    int64     // bytes written or sent so far, over every pass
+}
+
+// statsOf returns the FeedStats of e from its other methods and the bytes it
+// has written.
+func statsOf(e Emitter, written *byteCount) FeedStats {
+	done, _ := e.Progress()
+	return FeedStats{
+		Earliest: e.Earliest(),
+		Latest:   e.Latest(),
+		Len:      e.Len(),
+		Emitted:  done,
+		Warnings: len(e.Warnings()),
+		Bytes:    written.get(),
+	}
+}
+
+// byteCount tracks how many bytes an emitter has written to files or sent. Like
+// progress, it's written while the emitter runs and may be read from any
+// goroutine.
+type byteCount struct {
+	n int64
+}
+
+func (b *byteCount) add(n int64) {
+	atomic.AddInt64(&b.n, n)
+}
+
+func (b *byteCount) get() int64 {
+	return atomic.LoadInt64(&b.n)
+}
+
+// progress tracks how many items an emitter has advanced through. It's written
+// by the goroutine running the emitter and may be read from any other.
+type progress struct {
+	n int64
+}
+
+func (p *progress) set(n int) {
+	atomic.StoreInt64(&p.n, int64(n))
+}
+
+func (p *progress) get() int {
+	return int(atomic.LoadInt64(&p.n))
+}
+
+// Options are settings shared by every feed.
+type Options struct {
+	From        time.Time      // drop records before this cruise time, zero for no limit
+	To          time.Time      // drop records after this cruise time, zero for no limit
+	Paths       *PathTemplate  // layout of EVT, SFL, and OPP output files, nil for the default
+	Manifest    *Manifest      // checksums of EVT, SFL, and OPP output files, nil for none
+	DirMode     os.FileMode    // permissions of created output directories, 0 for 0755
+	FileMode    os.FileMode    // permissions of created output files, 0 for 0644
+	Copies      *CopyLimiter   // limits concurrent file copies and runs EVT copies in the background, nil for neither
+	CopyBuf     int            // EVT and OPP copy buffer size in bytes, 0 for io.Copy's default
+	Shift       time.Duration  // added to every record time as it's read, before filtering
+	TZ          *time.Location // zone for timestamp offsets and DOY directories, nil to force UTC
+	MaxWarnings int            // fail reading a feed with more warnings than this, 0 for no limit
+	Dedupe      bool           // drop records with the same time and data as an earlier one
+}
+
+// shift returns the cruise time t moved by o.Shift.
+func (o Options) shift(t time.Time) time.Time {
+	return t.Add(o.Shift)
+}
+
+// zone returns t in o.TZ, or in UTC if it's not set.
+func (o Options) zone(t time.Time) time.Time {
+	if o.TZ == nil {
+		return t.UTC()
+	}
+	return t.In(o.TZ)
+}
+
+// parseTime parses value with layout as in time.Parse. If o.TZ is set a value
+// without an offset is read as local time in o.TZ and the result is in o.TZ.
+func (o Options) parseTime(layout, value string) (time.Time, error) {
+	if o.TZ == nil {
+		return time.Parse(layout, value)
+	}
+	t, err := time.ParseInLocation(layout, value, o.TZ)
+	if err != nil {
+		return t, err
+	}
+	return t.In(o.TZ), nil
+}
+
+// copy copies src to dst with a buffer of o.CopyBuf bytes.
+func (o Options) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if o.CopyBuf <= 0 {
+		return io.Copy(dst, src)
+	}
+	return io.CopyBuffer(dst, src, make([]byte, o.CopyBuf))
+}
+
+func (o Options) dirMode() os.FileMode {
+	if o.DirMode == 0 {
+		return 0755
+	}
+	return o.DirMode
+}
+
+func (o Options) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return 0644
+	}
+	return o.FileMode
+}
+
+// outputPath returns where an output file named base for a record at t goes
+// under outDir. Without a path template it's dir/<year>_<doy>/base, with the
+// day of year in o.TZ.
+func (o Options) outputPath(outDir string, feed string, dir string, t time.Time, base string) (string, error) {
+	t = o.zone(t)
+	if o.Paths == nil {
+		doyDir := fmt.Sprintf("%d_%03d", t.Year(), t.YearDay())
+		return filepath.Join(outDir, dir, doyDir, base), nil
+	}
+	p, err := o.Paths.execute(pathFields{Feed: feed, Time: t, Year: t.Year(), YearDay: t.YearDay(), Base: base})
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(outDir, p), nil
+}
+
+// PathTemplate is a text/template for output file paths relative to the
+// output directory. Templates can use .Feed (evt, sfl, or opp), .Time, .Year,
+// .YearDay, and .Base, the output file name.
+type PathTemplate struct {
+	tmpl *template.Template
+}
+
+// pathFields are the values available to a PathTemplate.
+type pathFields struct {
+	Feed    string
+	Time    time.Time
+	Year    int
+	YearDay int
+	Base    string
+}
+
+// ParsePathTemplate parses text as a PathTemplate and checks that it produces
+// a usable relative path.
+func ParsePathTemplate(text string) (*PathTemplate, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &PathTemplate{tmpl: tmpl}
+	sample := pathFields{Feed: "evt", Time: time.Unix(0, 0).UTC(), Year: 1970, YearDay: 1, Base: "1970-01-01T00-00-00+00-00"}
+	if _, err := p.execute(sample); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *PathTemplate) execute(f pathFields) (string, error) {
+	var b strings.Builder
+	if err := p.tmpl.Execute(&b, f); err != nil {
+		return "", err
+	}
+	path := filepath.Clean(b.String())
+	if b.Len() == 0 || filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path template gave %q, want a path inside the output directory", b.String())
+	}
+	return path, nil
+}
+
+// keep reports whether a record at t, already shifted, is inside the From/To
+// window.
+func (o Options) keep(t time.Time) bool {
+	if !o.From.IsZero() && t.Before(o.From) {
+		return false
+	}
+	if !o.To.IsZero() && t.After(o.To) {
+		return false
+	}
+	return true
+}
+
+// checkWarnings returns an error once a feed being read has collected more
+// than o.MaxWarnings warnings, if a limit is set, so badly broken input fails
+// up front instead of replaying.
+func (o Options) checkWarnings(ws []Warning) error {
+	if o.MaxWarnings > 0 && len(ws) > o.MaxWarnings {
+		return fmt.Errorf("%v: giving up after more than %d warnings", ws[len(ws)-1], o.MaxWarnings)
+	}
+	return nil
+}
+
+// uniqueRecords returns the indices of the n records, sorted by time, that
+// aren't an exact repeat of an earlier record's time and data. rec returns the
+// time and data of the i'th record.
+func uniqueRecords(n int, rec func(i int) (time.Time, string)) []int {
+	keep := make([]int, 0, n)
+	var seen map[string]bool // data of records at the current time
+	var cur time.Time
+	for i := 0; i < n; i++ {
+		t, data := rec(i)
+		if seen == nil || !t.Equal(cur) {
+			seen = make(map[string]bool)
+			cur = t
+		}
+		if seen[data] {
+			continue
+		}
+		seen[data] = true
+		keep = append(keep, i)
+	}
+	return keep
+}
+
+// Warning is a problem with a feed that doesn't stop it. Emit returns a
+// Warning as its error when a record was skipped rather than failed.
 type Warning struct {
-	err error
+	err  error
+	feed string // name of the feed, e.g. sfl
+	kind string // category, one of the Warn* kinds
+	file string // input file the warning is about, "" if none
+	line int    // line number in file, 0 if none
+}
+
+// Warning kinds, for filtering and counting warnings by category.
+const (
+	WarnParse     = "parse"     // input that couldn't be parsed
+	WarnTimestamp = "timestamp" // a missing, bad, or out of place timestamp
+	WarnIO        = "io"        // a file or network operation that failed
+)
+
+// Feed returns the name of the feed the warning came from.
+func (w Warning) Feed() string {
+	return w.feed
+}
+
+// Kind returns the warning's category, one of the Warn* kinds.
+func (w Warning) Kind() string {
+	return w.kind
+}
+
+// File returns the input file the warning is about, or "" if it isn't about
+// one file.
+func (w Warning) File() string {
+	return w.file
+}
+
+// Line returns the line number in File the warning is about, or 0 if it isn't
+// about one line.
+func (w Warning) Line() int {
+	return w.line
 }
 
 func (w Warning) String() string {
 	return fmt.Sprintf("%v", w.err)
 }
 
-// timeFromFilename parses a SeaFlow timestamped filename. This function assumes
-// all times are UTC, even if they have non-UTC timezone designator.
-func timeFromFilename(fn string) (time.Time, error) {
+func (w Warning) Error() string {
+	return w.String()
+}
+
+// timeFromFilename parses a SeaFlow timestamped filename. With a nil loc this
+// function assumes all times are UTC, even if they have non-UTC timezone
+// designator. Otherwise the designator is honored, a name without one is read
+// as local time in loc, and the time is returned in loc.
+func timeFromFilename(fn string, loc *time.Location) (time.Time, error) {
 	fnbase := filepath.Base(fn)
-	re := regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2})-(\d{2})-(\d{2}(?:\.\d+)?)(?:.+)?$`)
+	re := regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2})-(\d{2})-(\d{2}(?:\.\d+)?)([\+\-]\d{2}-\d{2})?(?:.+)?$`)
 	subs := re.FindStringSubmatch(fnbase)
-	if len(subs) != 4 {
+	if len(subs) != 5 {
 		return time.Time{}, fmt.Errorf("file timtestamp could not be parsed for %v", fn)
 	}
-	ts := subs[1] + ":" + subs[2] + ":" + subs[3] + "+00:00"
-	return time.Parse(time.RFC3339, ts)
+	ts := subs[1] + ":" + subs[2] + ":" + subs[3]
+	if loc == nil {
+		return time.Parse(time.RFC3339, ts+"+00:00")
+	}
+	if subs[4] == "" {
+		return time.ParseInLocation("2006-01-02T15:04:05", ts, loc)
+	}
+	t, err := time.Parse(time.RFC3339, ts+subs[4][:3]+":"+subs[4][4:])
+	if err != nil {
+		return t, err
+	}
+	return t.In(loc), nil
 }
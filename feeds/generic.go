@@ -0,0 +1,274 @@
+package feeds
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Generic is a feed of rows from a delimited text file with a timestamp
+// column. Rows are appended unchanged to a single output file. Files ending
+// in .csv are comma-separated, anything else is tab-separated.
+type Generic struct {
+	i        int // index of next item to emit
+	progress progress
+	output   byteCount // bytes written or sent
+	data     []genericRecord
+	name     string
+	header   string // first line of the input if it isn't a data row
+	outPath  string
+	file     *os.File // current output file
+	truncate bool     // truncate output on next open, set after Reset
+	opts     Options
+	dupes    int // duplicate rows dropped by opts.Dedupe
+	warnings []Warning
+}
+
+// NewGeneric creates a feed from the rows of file. col is the zero-based index
+// of the timestamp column, parsed with layout as in time.Parse, or in opts.TZ
+// if it's set and the timestamp has no offset. If the first line doesn't have
+// a valid timestamp it's treated as a header and written at the top of
+// outPath. Only rows inside the time window in opts are kept.
+func NewGeneric(file string, col int, layout string, outPath string, opts Options) (g *Generic, err error) {
+	g = &Generic{i: -1, outPath: outPath, opts: opts}
+	g.name = "generic:" + filepath.Base(file)
+	g.data = []genericRecord{}
+	if col < 0 {
+		return g, fmt.Errorf("%v: timestamp column must be >= 0", g.name)
+	}
+	sep := "\t"
+	if strings.HasSuffix(strings.ToLower(file), ".csv") {
+		sep = ","
+	}
+
+	r, err := os.Open(file)
+	if err != nil {
+		return g, fmt.Errorf("%v: %v", g.name, err)
+	}
+	defer r.Close()
+
+	sc := bufio.NewScanner(r)
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		cols := strings.Split(line, sep)
+		var lineErr error
+		kind := WarnParse
+		if col >= len(cols) {
+			lineErr = fmt.Errorf("no column %d", col)
+		} else {
+			kind = WarnTimestamp
+			var t time.Time
+			if t, lineErr = opts.parseTime(layout, strings.TrimSpace(cols[col])); lineErr == nil {
+				t = opts.shift(t)
+				if !opts.keep(t) {
+					continue
+				}
+				g.data = append(g.data, genericRecord{time: t, line: lineNum, data: line})
+				continue
+			}
+		}
+		if lineNum == 1 {
+			g.header = line
+			continue
+		}
+		newErr := fmt.Errorf("%v: %s:%d: %v", g.name, file, lineNum, lineErr)
+		g.warnings = append(g.warnings, Warning{err: newErr, feed: g.name, kind: kind, file: file, line: lineNum})
+		if err = opts.checkWarnings(g.warnings); err != nil {
+			return g, err
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return g, fmt.Errorf("%v: %v", g.name, err)
+	}
+
+	// Sort by time, ascending, then by line number
+	sort.SliceStable(g.data, func(i, j int) bool {
+		if !g.data[i].time.Equal(g.data[j].time) {
+			return g.data[i].time.Before(g.data[j].time)
+		}
+		return g.data[i].line < g.data[j].line
+	})
+	g.dedupe()
+
+	return g, nil
+}
+
+// dedupe drops rows with the same time and text as an earlier one, if
+// opts.Dedupe is set.
+func (g *Generic) dedupe() {
+	if !g.opts.Dedupe {
+		return
+	}
+	keep := uniqueRecords(len(g.data), func(i int) (time.Time, string) { return g.data[i].time, g.data[i].data })
+	kept := make([]genericRecord, len(keep))
+	for j, i := range keep {
+		kept[j] = g.data[i]
+	}
+	g.dupes = len(g.data) - len(kept)
+	g.data = kept
+}
+
+func (g *Generic) Close() (err error) {
+	if g.file != nil {
+		err = g.file.Close()
+		g.file = nil
+		if err != nil {
+			return fmt.Errorf("%v: %v", g.name, err)
+		}
+	}
+	return
+}
+
+// Reset rewinds the feed. The output file is truncated when it's next opened
+// so a new pass doesn't append to the previous one.
+func (g *Generic) Reset() (err error) {
+	if err = g.Close(); err != nil {
+		return err
+	}
+	g.i = -1
+	g.progress.set(0)
+	g.truncate = true
+	return
+}
+
+func (g *Generic) Earliest() (t time.Time) {
+	if len(g.data) > 0 {
+		t = g.data[0].time
+	}
+	return
+}
+
+func (g *Generic) Latest() (t time.Time) {
+	if len(g.data) > 0 {
+		t = g.data[len(g.data)-1].time
+	}
+	return
+}
+
+func (g *Generic) Emit() (err error) {
+	if g.i < 0 {
+		return
+	}
+	if g.file == nil {
+		if err = g.open(); err != nil {
+			return fmt.Errorf("%v: %v", g.name, err)
+		}
+	}
+	n, err := g.file.WriteString(g.data[g.i].data + "\n")
+	g.output.add(int64(n))
+	if err != nil {
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+	return
+}
+
+// open opens the output file for appending, writing the input header first if
+// the file is empty.
+func (g *Generic) open() (err error) {
+	if err = os.MkdirAll(filepath.Dir(g.outPath), g.opts.dirMode()); err != nil {
+		return err
+	}
+	flag := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	if g.truncate {
+		flag |= os.O_TRUNC
+		g.truncate = false
+	}
+	if g.file, err = os.OpenFile(g.outPath, flag, g.opts.fileMode()); err != nil {
+		return err
+	}
+	if g.header == "" {
+		return
+	}
+	info, err := g.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		_, err = g.file.WriteString(g.header + "\n")
+	}
+	return
+}
+
+func (g *Generic) Target() string {
+	return g.outPath
+}
+
+// Seek positions the feed so that the next call to Next moves to the first
+// record at or after t. It reports whether any such record exists.
+func (g *Generic) Seek(t time.Time) bool {
+	idx := sort.Search(len(g.data), func(i int) bool {
+		return !g.data[i].time.Before(t)
+	})
+	g.i = idx - 1
+	g.progress.set(idx)
+	return idx < len(g.data)
+}
+
+func (g *Generic) Time() (t time.Time) {
+	if g.i >= 0 && len(g.data) > 0 {
+		t = g.data[g.i].time
+	}
+	return
+}
+
+func (g *Generic) Next() bool {
+	if g.i+1 < len(g.data) {
+		g.i++
+		g.progress.set(g.i + 1)
+		return true
+	}
+	return false
+}
+
+func (g *Generic) Warnings() []Warning {
+	return g.warnings
+}
+
+// Duplicates returns the number of rows dropped by opts.Dedupe.
+func (g *Generic) Duplicates() int {
+	return g.dupes
+}
+
+func (g *Generic) Name() string {
+	return g.name
+}
+
+func (g *Generic) Len() int {
+	return len(g.data)
+}
+
+func (g *Generic) Times() []time.Time {
+	ts := make([]time.Time, len(g.data))
+	for i, rec := range g.data {
+		ts[i] = rec.time
+	}
+	return ts
+}
+
+func (g *Generic) Progress() (done int, total int) {
+	return g.progress.get(), len(g.data)
+}
+
+func (g *Generic) Stats() FeedStats {
+	return statsOf(g, &g.output)
+}
+
+// genericRecord is one row of a generic feed file.
+type genericRecord struct {
+	time time.Time
+	line int // line number in the input file
+	data string
+}
+
+func (gr genericRecord) String() string {
+	return fmt.Sprintf("%v %s", gr.time, gr.data)
+}
@@ -0,0 +1,237 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// GlobFile is a feed of any series of files with timestamps in their names,
+// copied unchanged into an output directory at their scheduled times.
+type GlobFile struct {
+	i        int // index of next item to emit
+	progress progress
+	output   byteCount // bytes written or sent
+	data     []evtFile
+	name     string
+	outDir   string
+	opts     Options
+	warnings []Warning
+}
+
+// NewGlobFile creates a feed from the files matching pattern, as in
+// filepath.Glob. Each file's timestamp is the first submatch of re in its
+// base name, or the whole match if re has no groups, parsed with layout as in
+// time.Parse. Files are copied to outDir, and only those inside the time
+// window in opts are kept.
+func NewGlobFile(pattern string, re *regexp.Regexp, layout string, outDir string, opts Options) (g *GlobFile, err error) {
+	g = &GlobFile{i: -1, outDir: outDir, opts: opts}
+	g.name = "glob:" + filepath.Base(outDir)
+	g.data = []evtFile{}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return g, fmt.Errorf("%v: %v", g.name, err)
+	}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err != nil || fi.IsDir() {
+			continue
+		}
+		t, err := timeFromName(filepath.Base(f), re, layout, opts)
+		if err != nil {
+			g.warnings = append(g.warnings, Warning{err: fmt.Errorf("%v: skipping %s, bad timestamp: %v", g.name, f, err),
+				feed: g.name, kind: WarnTimestamp, file: f})
+			if err := opts.checkWarnings(g.warnings); err != nil {
+				return g, err
+			}
+			continue
+		}
+		t = opts.shift(t)
+		if !opts.keep(t) {
+			continue
+		}
+		g.data = append(g.data, evtFile{path: f, time: t})
+	}
+
+	// Sort by time, ascending, then by path
+	sort.SliceStable(g.data, func(i, j int) bool {
+		if !g.data[i].time.Equal(g.data[j].time) {
+			return g.data[i].time.Before(g.data[j].time)
+		}
+		return g.data[i].path < g.data[j].path
+	})
+
+	return g, nil
+}
+
+// timeFromName parses the timestamp re finds in name with layout, like
+// timeFromFilename but for any naming scheme.
+func timeFromName(name string, re *regexp.Regexp, layout string, opts Options) (time.Time, error) {
+	subs := re.FindStringSubmatch(name)
+	if subs == nil {
+		return time.Time{}, fmt.Errorf("no match for %v", re)
+	}
+	ts := subs[0]
+	if len(subs) > 1 {
+		ts = subs[1]
+	}
+	t, err := opts.parseTime(layout, ts)
+	if err != nil {
+		return t, err
+	}
+	if opts.TZ == nil {
+		t = t.UTC()
+	}
+	return t, nil
+}
+
+func (g *GlobFile) Close() (err error) {
+	return
+}
+
+func (g *GlobFile) Reset() (err error) {
+	g.i = -1
+	g.progress.set(0)
+	return
+}
+
+func (g *GlobFile) Earliest() (t time.Time) {
+	if len(g.data) > 0 {
+		t = g.data[0].time
+	}
+	return
+}
+
+func (g *GlobFile) Latest() (t time.Time) {
+	if len(g.data) > 0 {
+		t = g.data[len(g.data)-1].time
+	}
+	return
+}
+
+// Emit copies the current file into the output directory through a hidden
+// temp file, so consumers watching the directory only see complete files.
+func (g *GlobFile) Emit() (err error) {
+	if g.i < 0 {
+		return
+	}
+	outPath := g.Target()
+	if err = os.MkdirAll(g.outDir, g.opts.dirMode()); err != nil {
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+
+	g.opts.Copies.acquire()
+	defer g.opts.Copies.release()
+
+	src, err := os.Open(g.data[g.i].path)
+	if os.IsNotExist(err) {
+		return Warning{err: fmt.Errorf("%v: skipping %s, source file is gone", g.name, g.data[g.i].path),
+			feed: g.name, kind: WarnIO, file: g.data[g.i].path}
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(g.outDir, "."+filepath.Base(outPath)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+	tmpPath := dst.Name()
+	if err = dst.Chmod(g.opts.fileMode()); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+
+	// Hash while copying for the manifest
+	h := sha256.New()
+	n, err := g.opts.copy(io.MultiWriter(dst, h), src)
+	if err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+	if err = os.Rename(tmpPath, outPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("%v: %v", g.name, err)
+	}
+	g.output.add(n)
+	if g.opts.Manifest != nil {
+		if err = g.opts.Manifest.record(g.Name(), outPath, h, n); err != nil {
+			return fmt.Errorf("%v: %v", g.name, err)
+		}
+	}
+
+	return
+}
+
+func (g *GlobFile) Target() string {
+	if g.i < 0 {
+		return ""
+	}
+	return filepath.Join(g.outDir, filepath.Base(g.data[g.i].path))
+}
+
+// Seek positions the feed so that the next call to Next moves to the first
+// record at or after t. It reports whether any such record exists.
+func (g *GlobFile) Seek(t time.Time) bool {
+	idx := sort.Search(len(g.data), func(i int) bool {
+		return !g.data[i].time.Before(t)
+	})
+	g.i = idx - 1
+	g.progress.set(idx)
+	return idx < len(g.data)
+}
+
+func (g *GlobFile) Time() (t time.Time) {
+	if g.i >= 0 && len(g.data) > 0 {
+		t = g.data[g.i].time
+	}
+	return
+}
+
+func (g *GlobFile) Next() bool {
+	if g.i+1 < len(g.data) {
+		g.i++
+		g.progress.set(g.i + 1)
+		return true
+	}
+	return false
+}
+
+func (g *GlobFile) Warnings() []Warning {
+	return g.warnings
+}
+
+func (g *GlobFile) Name() string {
+	return g.name
+}
+
+func (g *GlobFile) Len() int {
+	return len(g.data)
+}
+
+func (g *GlobFile) Times() []time.Time {
+	ts := make([]time.Time, len(g.data))
+	for i, rec := range g.data {
+		ts[i] = rec.time
+	}
+	return ts
+}
+
+func (g *GlobFile) Progress() (done int, total int) {
+	return g.progress.get(), len(g.data)
+}
+
+func (g *GlobFile) Stats() FeedStats {
+	return statsOf(g, &g.output)
+}
@@ -0,0 +1,75 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Manifest records the SHA-256 checksum of every output file a replay writes,
+// one tab-separated line per file. It's safe for concurrent use by feeds.
+type Manifest struct {
+	mu     sync.Mutex
+	outDir string
+	file   *os.File
+}
+
+// NewManifest creates manifest.tsv in outDir, replacing any previous manifest.
+func NewManifest(outDir string) (m *Manifest, err error) {
+	m = &Manifest{outDir: outDir}
+	if m.file, err = os.Create(filepath.Join(outDir, "manifest.tsv")); err != nil {
+		return nil, fmt.Errorf("manifest: %v", err)
+	}
+	if _, err = io.WriteString(m.file, "time\tfeed\tpath\tsha256\tbytes\n"); err != nil {
+		m.file.Close()
+		return nil, fmt.Errorf("manifest: %v", err)
+	}
+	return m, nil
+}
+
+// record adds a line for the output file path written by feed, where h has
+// hashed the file's n bytes.
+func (m *Manifest) record(feed string, path string, h hash.Hash, n int64) error {
+	rel, err := filepath.Rel(m.outDir, path)
+	if err != nil {
+		rel = path
+	}
+	line := fmt.Sprintf("%s\t%s\t%s\t%s\t%d\n", time.Now().UTC().Format(time.RFC3339Nano), feed, rel,
+		hex.EncodeToString(h.Sum(nil)), n)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err = io.WriteString(m.file, line); err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	return nil
+}
+
+// recordFile hashes the output file at path and adds a line for it.
+func (m *Manifest) recordFile(feed string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	return m.record(feed, path, h, n)
+}
+
+func (m *Manifest) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.file.Close(); err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	return nil
+}
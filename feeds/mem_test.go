@@ -0,0 +1,97 @@
+package feeds
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testMemEmitter() *MemEmitter {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Out of order, NewMemEmitter sorts them
+	return NewMemEmitter("mem", []MemRecord{
+		{start.Add(2 * time.Second), "c"},
+		{start, "a"},
+		{start.Add(time.Second), "b"},
+	})
+}
+
+func TestMemEmitterNextEmit(t *testing.T) {
+	m := testMemEmitter()
+	if err := m.Emit(); err != nil || len(m.Emitted()) != 0 {
+		t.Errorf("Emit before Next = %v, emitted %q, want a no-op", err, m.Emitted())
+	}
+	var times []time.Time
+	for m.Next() {
+		times = append(times, m.Time())
+		if err := m.Emit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(m.Emitted(), want) {
+		t.Errorf("emitted %q, want %q", m.Emitted(), want)
+	}
+	if !reflect.DeepEqual(times, m.Times()) {
+		t.Errorf("emitted at %v, want Times %v", times, m.Times())
+	}
+	if !m.Earliest().Equal(times[0]) || !m.Latest().Equal(times[2]) {
+		t.Errorf("Earliest, Latest = %v, %v, want %v, %v", m.Earliest(), m.Latest(), times[0], times[2])
+	}
+	if done, total := m.Progress(); done != 3 || total != 3 {
+		t.Errorf("Progress = %d/%d, want 3/3", done, total)
+	}
+	if st := m.Stats(); st.Len != 3 || st.Emitted != 3 || st.Bytes != 3 {
+		t.Errorf("Stats = %+v, want 3 records emitted and 3 bytes", st)
+	}
+}
+
+func TestMemEmitterSeek(t *testing.T) {
+	m := testMemEmitter()
+	start := m.Earliest()
+	tests := []struct {
+		at   time.Time
+		ok   bool
+		next string // payload emitted after Next, "" if there's none
+	}{
+		{start.Add(-time.Hour), true, "a"},
+		{start, true, "a"},
+		{start.Add(time.Millisecond), true, "b"},
+		{start.Add(2 * time.Second), true, "c"},
+		{start.Add(3 * time.Second), false, ""},
+	}
+	for _, tt := range tests {
+		m.Reset()
+		if ok := m.Seek(tt.at); ok != tt.ok {
+			t.Errorf("Seek(%v) = %v, want %v", tt.at, ok, tt.ok)
+		}
+		if !m.Next() {
+			if tt.next != "" {
+				t.Errorf("after Seek(%v) no next record, want %s", tt.at, tt.next)
+			}
+			continue
+		}
+		m.Emit()
+		got := m.Emitted()
+		if got[len(got)-1] != tt.next {
+			t.Errorf("after Seek(%v) emitted %s, want %s", tt.at, got[len(got)-1], tt.next)
+		}
+	}
+}
+
+func TestMemEmitterReset(t *testing.T) {
+	m := testMemEmitter()
+	for pass := 0; pass < 2; pass++ {
+		if err := m.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		if done, _ := m.Progress(); done != 0 {
+			t.Errorf("Progress after Reset = %d, want 0", done)
+		}
+		for m.Next() {
+			m.Emit()
+		}
+	}
+	if want := []string{"a", "b", "c", "a", "b", "c"}; !reflect.DeepEqual(m.Emitted(), want) {
+		t.Errorf("emitted over two passes %q, want %q", m.Emitted(), want)
+	}
+}
@@ -0,0 +1,234 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// FindOPPFiles returns SeaFlow OPP files under dir, either legacy binary files
+// (optionally gzipped) or parquet files.
+func FindOPPFiles(dir string) (files []string, err error) {
+	ts := "????-??-??T??-??-??[\\-\\+]??-??"
+	patterns := []string{ts + ".opp", ts + ".opp.gz", ts + "*.opp.parquet"}
+
+	err = filepath.WalkDir(dir, func(walkPath string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if d == nil {
+				// Failed fs.Stat on root dir
+				return walkErr
+			}
+			// ReadDir failed on this directory
+			fmt.Fprintf(os.Stderr, "error: %v\n", walkErr)
+			return nil
+		}
+		if !d.IsDir() {
+			for _, pattern := range patterns {
+				found, matchErr := filepath.Match(pattern, d.Name())
+				if matchErr != nil {
+					panic(matchErr)
+				}
+				if found {
+					files = append(files, walkPath)
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Opp is a feed of SeaFlow OPP files, copied unchanged into the output
+// directory.
+type Opp struct {
+	i        int // index of next item to emit
+	progress progress
+	output   byteCount // bytes written or sent
+	data     []evtFile
+	outDir   string
+	opts     Options
+	warnings []Warning
+}
+
+// NewOpp creates an OPP feed from files, keeping those inside the time window
+// in opts.
+func NewOpp(files []string, outDir string, opts Options) (o *Opp, err error) {
+	o = &Opp{i: -1, opts: opts}
+	o.data = []evtFile{}
+	o.outDir = outDir
+	for _, f := range files {
+		t, err := timeFromFilename(f, opts.TZ)
+		if err != nil {
+			o.warnings = append(o.warnings, Warning{err: fmt.Errorf("opp: skipping %s, bad timestamp: %v", f, err), feed: "opp", kind: WarnTimestamp, file: f})
+			if err := opts.checkWarnings(o.warnings); err != nil {
+				return o, err
+			}
+			continue
+		}
+		t = opts.shift(t)
+		if !opts.keep(t) {
+			continue
+		}
+		o.data = append(o.data, evtFile{path: f, time: t})
+	}
+
+	// Sort by time, ascending, then by path
+	sort.SliceStable(o.data, func(i, j int) bool {
+		if !o.data[i].time.Equal(o.data[j].time) {
+			return o.data[i].time.Before(o.data[j].time)
+		}
+		return o.data[i].path < o.data[j].path
+	})
+
+	return o, nil
+}
+
+func (o *Opp) Close() (err error) {
+	return
+}
+
+func (o *Opp) Reset() (err error) {
+	o.i = -1
+	o.progress.set(0)
+	return
+}
+
+func (o *Opp) Earliest() (t time.Time) {
+	if len(o.data) > 0 {
+		t = o.data[0].time
+	}
+	return
+}
+
+func (o *Opp) Latest() (t time.Time) {
+	if len(o.data) > 0 {
+		t = o.data[len(o.data)-1].time
+	}
+	return
+}
+
+func (o *Opp) Emit() (err error) {
+	if o.i < 0 {
+		return
+	}
+	outPath, err := o.outPath()
+	if err != nil {
+		return fmt.Errorf("opp: %v", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(outPath), o.opts.dirMode()); err != nil {
+		return fmt.Errorf("opp: %v", err)
+	}
+
+	o.opts.Copies.acquire()
+	defer o.opts.Copies.release()
+
+	src, err := os.Open(o.data[o.i].path)
+	if err != nil {
+		return fmt.Errorf("opp: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(outPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, o.opts.fileMode())
+	if err != nil {
+		return fmt.Errorf("opp: %v", err)
+	}
+
+	// Hash while copying for the manifest
+	h := sha256.New()
+	// Don't leave a truncated file behind if the copy fails
+	n, err := o.opts.copy(io.MultiWriter(dst, h), src)
+	if err != nil {
+		dst.Close()
+		os.Remove(outPath)
+		return fmt.Errorf("opp: %v", err)
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(outPath)
+		return fmt.Errorf("opp: %v", err)
+	}
+	o.output.add(n)
+	if o.opts.Manifest != nil {
+		if err = o.opts.Manifest.record(o.Name(), outPath, h, n); err != nil {
+			return fmt.Errorf("opp: %v", err)
+		}
+	}
+
+	return
+}
+
+// outPath returns the output path for the current OPP file.
+func (o *Opp) outPath() (string, error) {
+	return o.opts.outputPath(o.outDir, "opp", filepath.Join("datafiles", "opp"), o.data[o.i].time, filepath.Base(o.data[o.i].path))
+}
+
+func (o *Opp) Target() string {
+	if o.i < 0 {
+		return ""
+	}
+	outPath, err := o.outPath()
+	if err != nil {
+		return ""
+	}
+	return outPath
+}
+
+// Seek positions the feed so that the next call to Next moves to the first
+// record at or after t. It reports whether any such record exists.
+func (o *Opp) Seek(t time.Time) bool {
+	idx := sort.Search(len(o.data), func(i int) bool {
+		return !o.data[i].time.Before(t)
+	})
+	o.i = idx - 1
+	o.progress.set(idx)
+	return idx < len(o.data)
+}
+
+func (o *Opp) Time() (t time.Time) {
+	if o.i >= 0 && len(o.data) > 0 {
+		t = o.data[o.i].time
+	}
+	return
+}
+
+func (o *Opp) Next() bool {
+	if o.i+1 < len(o.data) {
+		o.i++
+		o.progress.set(o.i + 1)
+		return true
+	}
+	return false
+}
+
+func (o *Opp) Warnings() []Warning {
+	return o.warnings
+}
+
+func (o *Opp) Name() string {
+	return "opp"
+}
+
+func (o *Opp) Len() int {
+	return len(o.data)
+}
+
+func (o *Opp) Times() []time.Time {
+	ts := make([]time.Time, len(o.data))
+	for i, rec := range o.data {
+		ts[i] = rec.time
+	}
+	return ts
+}
+
+func (o *Opp) Progress() (done int, total int) {
+	return o.progress.get(), len(o.data)
+}
+
+func (o *Opp) Stats() FeedStats {
+	return statsOf(o, &o.output)
+}
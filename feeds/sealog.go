@@ -2,10 +2,13 @@ package feeds
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/seaflow-uw/seaflog"
@@ -13,47 +16,187 @@ import (
 
 type SeaLog struct {
 	i        int // index of next item to emit
+	progress progress
+	output   byteCount // bytes written or sent
 	data     []seaLogRecord
 	outDir   string
 	file     *os.File // current output file
+	truncate bool     // truncate output on next open, set after Reset
+	opts     SeaLogOptions
+	dupes    int // duplicate events dropped by opts.Dedupe
+	skipped  int // events left out by opts.Events
 	warnings []Warning
 }
 
-func NewSeaLog(file string, outDir string) (s *SeaLog, err error) {
-	s = &SeaLog{i: -1}
+// SeaLogOptions configures a SeaLog feed.
+type SeaLogOptions struct {
+	Options
+	Fsync    bool     // sync output to disk after every record
+	Verbatim bool     // write events exactly as they appear in the input log
+	Events   []string // keep only events with these names, nil for all
+}
+
+// NewSeaLog creates a SeaFlow instrument log feed from file, or stdin if file
+// is "-".
+func NewSeaLog(file string, outDir string, opts SeaLogOptions) (s *SeaLog, err error) {
+	s = &SeaLog{i: -1, opts: opts}
 	s.data = []seaLogRecord{}
 	s.outDir = outDir
+	for _, name := range opts.Events {
+		if _, ok := seaflog.EventDefs[name]; !ok {
+			return s, fmt.Errorf("seaflowlog: unknown event %q, choose from %q", name, EventNames())
+		}
+	}
 
-	r, err := os.Open(file)
-	if err != nil {
-		return s, fmt.Errorf("seaflowlog: %v", err)
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return s, fmt.Errorf("seaflowlog: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	if err = s.read(r, file); err != nil {
+		return s, err
 	}
-	defer r.Close()
-	bufr := bufio.NewReader(r)
+	return s, nil
+}
 
-	sc := seaflog.NewEventScanner(bufr)
+// read appends the events in r, the contents of file, to s.data. The whole log
+// is read up front, so a log from stdin can still be replayed more than once.
+//
+// With opts.Verbatim each record also keeps the raw input text since the
+// previous record, including timestamp lines, skipped lines, and original line
+// endings, so writing every record's raw text reproduces the input exactly.
+func (s *SeaLog) read(r io.Reader, file string) (err error) {
+	var lines []string // raw input lines with line endings, for verbatim output
+	if s.opts.Verbatim {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("seaflowlog: %v", err)
+		}
+		lines = strings.SplitAfter(string(b), "\n")
+		r = bytes.NewReader(b)
+	}
+	prev := 0     // lines before this index belong to earlier records
+	pending := "" // raw text around events left out by opts.Events, for the next record
+	sc := seaflog.NewEventScanner(bufio.NewReader(r))
 	for sc.Scan() {
 		event := sc.Event()
 		if event.Name != "unhandled" {
-			s.data = append(s.data, seaLogRecord{time: event.Time, data: event.Line})
+			if !s.wanted(event.Name) {
+				if s.opts.Verbatim {
+					// Drop only the event's own line, the next record still
+					// needs any timestamp line before it
+					pending += strings.Join(lines[prev:event.LineNumber-1], "")
+					prev = event.LineNumber
+				}
+				s.skipped++
+				continue
+			}
+			var raw string
+			if s.opts.Verbatim {
+				raw = pending + strings.Join(lines[prev:event.LineNumber], "")
+				pending = ""
+				prev = event.LineNumber
+			}
+			t := s.opts.shift(event.Time)
+			if !s.opts.keep(t) {
+				continue
+			}
+			s.data = append(s.data, seaLogRecord{time: t, line: event.LineNumber, data: event.Line, raw: raw})
 		} else {
 			newErr := fmt.Errorf("seaflowlog: unhandled event at line %d: %s", event.LineNumber, event.Line)
-			s.warnings = append(s.warnings, Warning{err: newErr})
+			s.warnings = append(s.warnings, Warning{err: newErr, feed: "seaflowlog", kind: WarnParse, file: file, line: event.LineNumber})
+			if err = s.opts.checkWarnings(s.warnings); err != nil {
+				return err
+			}
 		}
 	}
 	if err = sc.Err(); err != nil {
-		return s, fmt.Errorf("seaflowlog: %v", err)
+		return fmt.Errorf("seaflowlog: %v", err)
+	}
+	if s.opts.Verbatim && len(s.data) > 0 {
+		// Keep anything after the last event
+		s.data[len(s.data)-1].raw += pending + strings.Join(lines[prev:], "")
 	}
 
-	// Sort by time, ascending
+	// Sort by time, ascending, then by line number
 	sort.SliceStable(s.data, func(i, j int) bool {
-		return s.data[i].time.Before(s.data[j].time)
+		if !s.data[i].time.Equal(s.data[j].time) {
+			return s.data[i].time.Before(s.data[j].time)
+		}
+		return s.data[i].line < s.data[j].line
 	})
+	s.dedupe()
 
-	return s, nil
+	return nil
+}
+
+// dedupe drops events with the same time and text as an earlier one, if
+// opts.Dedupe is set.
+func (s *SeaLog) dedupe() {
+	if !s.opts.Dedupe {
+		return
+	}
+	keep := uniqueRecords(len(s.data), func(i int) (time.Time, string) { return s.data[i].time, s.data[i].data })
+	kept := make([]seaLogRecord, len(keep))
+	for j, i := range keep {
+		kept[j] = s.data[i]
+	}
+	s.dupes = len(s.data) - len(kept)
+	s.data = kept
+}
+
+// wanted reports whether events named name are kept under opts.Events.
+func (s *SeaLog) wanted(name string) bool {
+	if s.opts.Events == nil {
+		return true
+	}
+	for _, n := range s.opts.Events {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// EventNames returns the sorted names of SeaFlow instrument log events.
+func EventNames() []string {
+	names := make([]string, 0, len(seaflog.EventDefs))
+	for k := range seaflog.EventDefs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Skipped returns the number of events left out by opts.Events.
+func (s *SeaLog) Skipped() int {
+	return s.skipped
 }
 
 func (s *SeaLog) Close() (err error) {
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+		if err != nil {
+			return fmt.Errorf("seaflowlog: %v", err)
+		}
+	}
+	return
+}
+
+// Reset rewinds the feed. The output log is truncated when it's next opened
+// so a new pass doesn't append to the previous one.
+func (s *SeaLog) Reset() (err error) {
+	if err = s.Close(); err != nil {
+		return err
+	}
+	s.i = -1
+	s.progress.set(0)
+	s.truncate = true
 	return
 }
 
@@ -64,26 +207,65 @@ func (s *SeaLog) Earliest() (t time.Time) {
 	return
 }
 
+func (s *SeaLog) Latest() (t time.Time) {
+	if len(s.data) > 0 {
+		t = s.data[len(s.data)-1].time
+	}
+	return
+}
+
 func (s *SeaLog) Emit() (err error) {
 	if s.i < 0 {
 		return
 	}
 	rec := s.data[s.i]
-	outDir := filepath.Join(s.outDir, "datafiles")
-	if err = os.MkdirAll(outDir, os.ModePerm); err != nil {
+	outPath := s.Target()
+	if err = os.MkdirAll(filepath.Dir(outPath), s.opts.dirMode()); err != nil {
 		return fmt.Errorf("seaflowlog: %v", err)
 	}
-	outPath := filepath.Join(outDir, "SFlog.txt")
 	if s.file == nil {
-		if s.file, err = os.OpenFile(outPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.ModePerm); err != nil {
+		flag := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+		if s.truncate {
+			flag |= os.O_TRUNC
+			s.truncate = false
+		}
+		if s.file, err = os.OpenFile(outPath, flag, s.opts.fileMode()); err != nil {
+			return fmt.Errorf("seaflowlog: %v", err)
+		}
+	}
+	out := fmt.Sprintf("%s\r\n%s\r\n", rec.logTime(s.opts.TZ), rec.data)
+	if s.opts.Verbatim {
+		out = rec.raw
+	}
+	n, err := s.file.WriteString(out)
+	s.output.add(int64(n))
+	if err != nil {
+		return fmt.Errorf("seaflowlog: %v", err)
+	}
+	if s.opts.Fsync {
+		if err = s.file.Sync(); err != nil {
 			return fmt.Errorf("seaflowlog: %v", err)
 		}
 	}
-	s.file.WriteString(fmt.Sprintf("%s\r\n", rec))
 
 	return
 }
 
+func (s *SeaLog) Target() string {
+	return filepath.Join(s.outDir, "datafiles", "SFlog.txt")
+}
+
+// Seek positions the feed so that the next call to Next moves to the first
+// record at or after t. It reports whether any such record exists.
+func (s *SeaLog) Seek(t time.Time) bool {
+	idx := sort.Search(len(s.data), func(i int) bool {
+		return !s.data[i].time.Before(t)
+	})
+	s.i = idx - 1
+	s.progress.set(idx)
+	return idx < len(s.data)
+}
+
 func (s *SeaLog) Time() (t time.Time) {
 	if s.i >= 0 && len(s.data) > 0 {
 		t = s.data[s.i].time
@@ -94,6 +276,7 @@ func (s *SeaLog) Time() (t time.Time) {
 func (s *SeaLog) Next() bool {
 	if s.i+1 < len(s.data) {
 		s.i++
+		s.progress.set(s.i + 1)
 		return true
 	}
 	return false
@@ -103,6 +286,11 @@ func (s *SeaLog) Warnings() []Warning {
 	return s.warnings
 }
 
+// Duplicates returns the number of events dropped by opts.Dedupe.
+func (s *SeaLog) Duplicates() int {
+	return s.dupes
+}
+
 func (s *SeaLog) Name() string {
 	return "seaflowlog"
 }
@@ -111,18 +299,39 @@ func (s *SeaLog) Len() int {
 	return len(s.data)
 }
 
+func (s *SeaLog) Times() []time.Time {
+	ts := make([]time.Time, len(s.data))
+	for i, rec := range s.data {
+		ts[i] = rec.time
+	}
+	return ts
+}
+
+func (s *SeaLog) Progress() (done int, total int) {
+	return s.progress.get(), len(s.data)
+}
+
+func (s *SeaLog) Stats() FeedStats {
+	return statsOf(s, &s.output)
+}
+
 // seaLogRecord represents data from one time point in a SeaFlow V1 instrument log
 type seaLogRecord struct {
 	time time.Time
+	line int // line number of the event in the input log
 	data string
+	raw  string // input text since the previous record, set for verbatim output
 }
 
 func (sr seaLogRecord) String() string {
-	return fmt.Sprintf("%s\r\n%s", sr.logTime(), sr.data)
+	return fmt.Sprintf("%s\r\n%s", sr.logTime(nil), sr.data)
 }
 
-func (sr seaLogRecord) logTime() string {
-	tstr := sr.time.UTC().Format(time.RFC3339)
-	tstr = tstr[:13] + "-" + tstr[14:16] + "-" + tstr[17:len(tstr)-1] + "+00:00"
-	return tstr
+// logTime formats the record's time as a SeaFlow log timestamp in loc, or UTC
+// if loc is nil.
+func (sr seaLogRecord) logTime(loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return sr.time.In(loc).Format("2006-01-02T15-04-05-07:00")
 }
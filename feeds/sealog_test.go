@@ -0,0 +1,86 @@
+package feeds
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSeaLog = "2021-01-01T00-00-20+00-00\nPMT1:1.05\r\n2021-01-01T00-01-20+00-00\nPMT2:1.10\nweird line\n"
+
+func TestSeaLogRead(t *testing.T) {
+	s := &SeaLog{i: -1}
+	if err := s.read(strings.NewReader(testSeaLog), "test.log"); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		time time.Time
+		line int
+	}{
+		{time.Date(2021, 1, 1, 0, 0, 20, 0, time.UTC), 2},
+		{time.Date(2021, 1, 1, 0, 1, 20, 0, time.UTC), 4},
+	}
+	if len(s.data) != len(want) {
+		t.Fatalf("read %d events %v, want %d", len(s.data), s.data, len(want))
+	}
+	for i, w := range want {
+		if got := s.data[i]; !got.time.Equal(w.time) || got.line != w.line {
+			t.Errorf("event %d at %v line %d, want %v line %d", i, got.time, got.line, w.time, w.line)
+		}
+	}
+	if len(s.warnings) != 1 || s.warnings[0].line != 5 || s.warnings[0].file != "test.log" {
+		t.Errorf("warnings = %v, want one for test.log:5", s.warnings)
+	}
+}
+
+func TestSeaLogReadVerbatim(t *testing.T) {
+	s := &SeaLog{i: -1, opts: SeaLogOptions{Verbatim: true}}
+	if err := s.read(strings.NewReader(testSeaLog), "test.log"); err != nil {
+		t.Fatal(err)
+	}
+	var raw strings.Builder
+	for _, rec := range s.data {
+		raw.WriteString(rec.raw)
+	}
+	if raw.String() != testSeaLog {
+		t.Errorf("verbatim events = %q, want the input %q", raw.String(), testSeaLog)
+	}
+}
+
+func TestSeaLogReadEvents(t *testing.T) {
+	s := &SeaLog{i: -1, opts: SeaLogOptions{Events: []string{"PMT2"}}}
+	if err := s.read(strings.NewReader(testSeaLog), "test.log"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.data) != 1 || s.data[0].line != 4 {
+		t.Errorf("events = %v, want only the PMT2 event on line 4", s.data)
+	}
+	if s.Skipped() != 1 {
+		t.Errorf("skipped %d events, want 1", s.Skipped())
+	}
+}
+
+func TestNewSeaLogStdin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(path, []byte(testSeaLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	s, err := NewSeaLog("-", t.TempDir(), SeaLogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("read %d events from stdin, want 2", s.Len())
+	}
+}
@@ -0,0 +1,76 @@
+package feeds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// serialWriteTimeout is how long a write to a serial device may block, e.g.
+// on hardware flow control, before the record is dropped.
+const serialWriteTimeout = time.Second
+
+// serialConn writes to a serial device, reopening it on the next write if it
+// was busy or a write failed.
+type serialConn struct {
+	device string
+	baud   int
+	file   *os.File
+}
+
+// openDevice opens a serial device for writing. It's a variable so tests can
+// simulate devices that are busy or missing.
+var openDevice = func(device string) (*os.File, error) {
+	return os.OpenFile(device, os.O_WRONLY|syscall.O_NOCTTY, 0)
+}
+
+// open opens and configures the device. Errors wrap the underlying error so
+// callers can tell a busy device with isBusy.
+func (c *serialConn) open() (err error) {
+	f, err := openDevice(c.device)
+	if err != nil {
+		return fmt.Errorf("serial: %w", err)
+	}
+	if err = configureSerial(f, c.baud); err != nil {
+		f.Close()
+		return fmt.Errorf("serial: %v: %w", c.device, err)
+	}
+	c.file = f
+	return nil
+}
+
+func (c *serialConn) Write(b []byte) (n int, err error) {
+	if c.file == nil {
+		if err = c.open(); err != nil {
+			return 0, err
+		}
+	}
+	// Not every device supports deadlines, in which case writes just block
+	c.file.SetWriteDeadline(time.Now().Add(serialWriteTimeout))
+	n, err = c.file.Write(b)
+	if err == nil {
+		return n, nil
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, fmt.Errorf("serial: %v: write timed out after %v", c.device, serialWriteTimeout)
+	}
+	// The device may have been unplugged, reopen it on the next write
+	c.file.Close()
+	c.file = nil
+	return n, fmt.Errorf("serial: %v", err)
+}
+
+func (c *serialConn) Close() (err error) {
+	if c.file != nil {
+		err = c.file.Close()
+		c.file = nil
+	}
+	return
+}
+
+// isBusy reports whether err is from opening a device another process holds.
+func isBusy(err error) bool {
+	return errors.Is(err, syscall.EBUSY)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package feeds
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// configureSerial is only implemented for Linux.
+func configureSerial(f *os.File, baud int) error {
+	return fmt.Errorf("serial output is not supported on %v", runtime.GOOS)
+}
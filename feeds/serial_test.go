@@ -0,0 +1,49 @@
+package feeds
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSerialOpenBusy(t *testing.T) {
+	defer func(orig func(string) (*os.File, error)) { openDevice = orig }(openDevice)
+	openDevice = func(device string) (*os.File, error) {
+		return nil, &os.PathError{Op: "open", Path: device, Err: syscall.EBUSY}
+	}
+
+	c := &serialConn{device: "/dev/ttyTEST0", baud: 4800}
+	err := c.open()
+	if !isBusy(err) {
+		t.Fatalf("open error %v isn't busy", err)
+	}
+
+	tr := Transport{Proto: "serial", Device: "/dev/ttyTEST0", Baud: 4800}
+	conn, err := tr.Open()
+	if err != nil {
+		t.Fatalf("Open with a busy device: %v", err)
+	}
+	if conn == nil {
+		t.Fatal("Open with a busy device returned no conn")
+	}
+	// Writes retry the open, and fail while the device stays busy
+	if _, err := conn.Write([]byte("$GPGGA\r\n")); !isBusy(err) {
+		t.Errorf("Write while busy = %v, want a busy error", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestSerialOpenMissing(t *testing.T) {
+	defer func(orig func(string) (*os.File, error)) { openDevice = orig }(openDevice)
+	openDevice = func(device string) (*os.File, error) {
+		return nil, &os.PathError{Op: "open", Path: device, Err: syscall.ENOENT}
+	}
+
+	tr := Transport{Proto: "serial", Device: "/dev/ttyTEST0", Baud: 4800}
+	if _, err := tr.Open(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open with a missing device = %v, want a not-exist error", err)
+	}
+}
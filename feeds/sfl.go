@@ -2,9 +2,10 @@ package feeds
 
 import (
 	"bufio"
+	"compress/gzip"
 	"fmt"
+	"io"
 	"io/fs"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,6 +15,7 @@ import (
 
 func FindSFLFiles(dir string) (files []string, err error) {
 	pattern := "????-??-??T??-??-??[\\-\\+]??-??.sfl"
+	patterngz := pattern + ".gz"
 
 	err = filepath.WalkDir(dir, func(walkPath string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
@@ -33,6 +35,15 @@ func FindSFLFiles(dir string) (files []string, err error) {
 			}
 			if found {
 				files = append(files, walkPath)
+			} else {
+				// Look for gzipped SFL files
+				found, matchErr = filepath.Match(patterngz, d.Name())
+				if matchErr != nil {
+					panic(matchErr)
+				}
+				if found {
+					files = append(files, walkPath)
+				}
 			}
 		}
 		return nil
@@ -43,68 +54,225 @@ func FindSFLFiles(dir string) (files []string, err error) {
 // *****************************************************************************
 type Sfl struct {
 	i        int // index of next item to emit
+	progress progress
+	output   byteCount // bytes written or sent
 	data     []sflRecord
 	paths    []string
+	headers  []string // header line of each input file, parallel to paths
 	outDir   string
-	file     *os.File // current output file
+	file     *os.File       // current output file
+	gz       *gzip.Writer   // compressor for file when opts.Compress is set
+	conn     io.WriteCloser // datagram destination when opts.UDP is set
+	opts     SflOptions
+	written  map[string]bool // output files opened during this pass
+	truncate map[string]bool // output files to truncate on next open, set by Reset
+	dupes    int             // duplicate records dropped by opts.Dedupe
 	warnings []Warning
 }
 
-func NewSfl(files []string, outDir string) (s *Sfl, err error) {
-	s = &Sfl{i: -1}
+// SflOptions configures an Sfl feed.
+type SflOptions struct {
+	Options
+	Compress bool       // write gzipped output files with a .gz suffix
+	Fsync    bool       // flush and sync output to disk after every record
+	UDP      *Transport // also send each record to this destination, nil for none
+	NoFiles  bool       // with UDP, send records without writing output files
+}
+
+// NewSfl creates an SFL feed from files.
+func NewSfl(files []string, outDir string, opts SflOptions) (s *Sfl, err error) {
+	s = &Sfl{i: -1, opts: opts}
 	s.data = []sflRecord{}
+	s.written = make(map[string]bool)
+	s.truncate = make(map[string]bool)
 	s.outDir = outDir
+	s.headers = make([]string, len(files))
+	if opts.UDP != nil {
+		if s.conn, err = opts.UDP.Open(); err != nil {
+			return s, fmt.Errorf("sfl: %v", err)
+		}
+	} else if opts.NoFiles {
+		return s, fmt.Errorf("sfl: no output, file output is off and no UDP destination is set")
+	}
 	for idx, f := range files {
-		fileText, err := ioutil.ReadFile(f)
-		if err != nil {
+		s.paths = append(s.paths, f)
+		if err = s.readFile(idx, f); err != nil {
 			return s, err
 		}
-		sc := bufio.NewScanner(strings.NewReader(string(fileText)))
-		lineNum := 0
-		var header string
-		for sc.Scan() {
-			lineNum++
-			lineText := sc.Text()
-			if lineNum == 1 {
-				s.paths = append(s.paths, f)
-				header = sc.Text()
-				continue
-			}
-			cols := strings.Split(lineText, "\t")
-			if len(cols) > 1 && len(cols[0]) == 25 {
-				tstamp := cols[0][:19] + "+00:00" // TZ untrustworthy, force UTC
-				tstamp = tstamp[:13] + ":" + tstamp[14:16] + ":" + tstamp[17:]
-				lineTime, err := time.Parse(time.RFC3339, tstamp)
-				if err != nil {
-					// Skip this line
-					newErr := fmt.Errorf("sfl: could not parse timestamp %s:%d %v", f, lineNum, err)
-					s.warnings = append(s.warnings, Warning{err: newErr})
-					continue
-				}
-				if lineNum == 2 {
-					lineText = header + "\r\n" + lineText
-				}
-				s.data = append(s.data, sflRecord{time: lineTime, data: lineText, idx: idx})
-			} else {
-				newErr := fmt.Errorf("sfl: unparsable line %s:%d", f, lineNum)
-				s.warnings = append(s.warnings, Warning{err: newErr})
-			}
-		}
 	}
 
-	// Sort by time, ascending
+	// Sort by time, ascending, then by file path and line number so ties
+	// replay in the same order regardless of input order
 	sort.SliceStable(s.data, func(i, j int) bool {
-		return s.data[i].time.Before(s.data[j].time)
+		a, b := s.data[i], s.data[j]
+		if !a.time.Equal(b.time) {
+			return a.time.Before(b.time)
+		}
+		if s.paths[a.idx] != s.paths[b.idx] {
+			return s.paths[a.idx] < s.paths[b.idx]
+		}
+		return a.line < b.line
 	})
+	s.dedupe()
 
 	return s, nil
 }
 
+// dedupe drops rows with the same time and text as an earlier one, if
+// opts.Dedupe is set.
+func (s *Sfl) dedupe() {
+	if !s.opts.Dedupe {
+		return
+	}
+	keep := uniqueRecords(len(s.data), func(i int) (time.Time, string) { return s.data[i].time, s.data[i].data })
+	kept := make([]sflRecord, len(keep))
+	for j, i := range keep {
+		kept[j] = s.data[i]
+	}
+	s.dupes = len(s.data) - len(kept)
+	s.data = kept
+}
+
+// sflMaxLine is the longest SFL line that can be read. Lines with many
+// columns can exceed bufio.Scanner's 64KB default.
+const sflMaxLine = 1024 * 1024
+
+// readFile scans the SFL file at path, the idx'th input file, appending its
+// records to s.data. The file is streamed line by line rather than read into
+// memory, and decompressed first if it ends in .gz.
+func (s *Sfl) readFile(idx int, path string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("sfl: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("sfl: %s: %v", path, err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), sflMaxLine)
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		lineText := sc.Text()
+		if lineNum == 1 {
+			s.headers[idx] = lineText
+			continue
+		}
+		cols := strings.Split(lineText, "\t")
+		if len(cols) > 1 && len(cols[0]) == 25 {
+			offset := "+00:00" // TZ untrustworthy, force UTC unless opts.TZ is set
+			if s.opts.TZ != nil {
+				offset = cols[0][19:22] + ":" + cols[0][23:]
+			}
+			tstamp := cols[0][:19] + offset
+			tstamp = tstamp[:13] + ":" + tstamp[14:16] + ":" + tstamp[17:]
+			lineTime, err := time.Parse(time.RFC3339, tstamp)
+			if err != nil {
+				// Skip this line
+				newErr := fmt.Errorf("sfl: could not parse timestamp %s:%d %v", path, lineNum, err)
+				s.warnings = append(s.warnings, Warning{err: newErr, feed: "sfl", kind: WarnTimestamp, file: path, line: lineNum})
+				if err := s.opts.checkWarnings(s.warnings); err != nil {
+					return err
+				}
+				continue
+			}
+			lineTime = s.opts.shift(s.opts.zone(lineTime))
+			if !s.opts.keep(lineTime) {
+				continue
+			}
+			s.data = append(s.data, sflRecord{time: lineTime, data: lineText, idx: idx, line: lineNum})
+		} else {
+			newErr := fmt.Errorf("sfl: unparsable line %s:%d", path, lineNum)
+			s.warnings = append(s.warnings, Warning{err: newErr, feed: "sfl", kind: WarnParse, file: path, line: lineNum})
+			if err = s.opts.checkWarnings(s.warnings); err != nil {
+				return err
+			}
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return fmt.Errorf("sfl: %s:%d: %v", path, lineNum+1, err)
+	}
+	return
+}
+
 func (s *Sfl) Close() (err error) {
+	err = s.closeFile()
+	if err == nil && s.opts.Manifest != nil {
+		err = s.recordOutputs()
+	}
+	if s.conn != nil {
+		if connErr := s.conn.Close(); connErr != nil && err == nil {
+			err = fmt.Errorf("sfl: %v", connErr)
+		}
+		s.conn = nil
+	}
+	return
+}
+
+// closeFile closes the current output file, if any.
+func (s *Sfl) closeFile() (err error) {
+	if s.gz != nil {
+		// Write the gzip trailer before closing the file
+		err = s.gz.Close()
+		s.gz = nil
+	}
 	if s.file != nil {
-		err = s.Close()
-		s = nil
+		if ferr := s.file.Close(); err == nil {
+			err = ferr
+		}
+		s.file = nil
+	}
+	if err != nil {
+		return fmt.Errorf("sfl: %v", err)
+	}
+	return
+}
+
+// recordOutputs adds each output file to the manifest once, when the feed is
+// closed. Files are reopened whenever records from several sources
+// interleave, so only the finished file's checksum is meaningful. Files
+// written in an earlier loop but not yet rewritten in this one are included
+// too.
+func (s *Sfl) recordOutputs() error {
+	paths := []string{}
+	for p := range s.written {
+		paths = append(paths, p)
+	}
+	for p := range s.truncate {
+		if !s.written[p] {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	s.written = make(map[string]bool)
+	s.truncate = make(map[string]bool)
+	for _, p := range paths {
+		if err := s.opts.Manifest.recordFile(s.Name(), p); err != nil {
+			return fmt.Errorf("sfl: %v", err)
+		}
+	}
+	return nil
+}
+
+// Reset rewinds the feed. Output files written during the previous pass are
+// truncated when they're next opened so headers and records aren't duplicated.
+func (s *Sfl) Reset() (err error) {
+	if err = s.closeFile(); err != nil {
+		return err
 	}
+	s.i = -1
+	s.progress.set(0)
+	s.truncate = s.written
+	s.written = make(map[string]bool)
 	return
 }
 
@@ -115,33 +283,153 @@ func (s *Sfl) Earliest() (t time.Time) {
 	return
 }
 
+func (s *Sfl) Latest() (t time.Time) {
+	if len(s.data) > 0 {
+		t = s.data[len(s.data)-1].time
+	}
+	return
+}
+
 func (s *Sfl) Emit() (err error) {
 	if s.i < 0 {
 		return
 	}
 	rec := s.data[s.i]
-	outFileTime, err := timeFromFilename(s.paths[rec.idx])
+	if !s.opts.NoFiles {
+		if err = s.emitFile(rec); err != nil {
+			return err
+		}
+	}
+	if s.conn != nil {
+		return s.send(rec)
+	}
+	return
+}
+
+// send sends rec as a single datagram. A record that can't be sent because of
+// a transient network error is dropped with a Warning.
+func (s *Sfl) send(rec sflRecord) (err error) {
+	n, err := s.conn.Write([]byte(rec.data + "\n"))
+	s.output.add(int64(n))
+	if err != nil {
+		if isTransient(err) {
+			return Warning{err: fmt.Errorf("sfl: dropped UDP datagram at %v: %v", rec.time, err), feed: "sfl", kind: WarnIO}
+		}
+		return fmt.Errorf("sfl: %v", err)
+	}
+	return
+}
+
+// emitFile appends rec to its output file, starting the file with its
+// source's header if it's empty.
+func (s *Sfl) emitFile(rec sflRecord) (err error) {
+	outPath, err := s.outPath(rec)
 	if err != nil {
 		return fmt.Errorf("sfl: %v", err)
 	}
-	doyDir := fmt.Sprintf("%d_%03d", outFileTime.Year(), outFileTime.YearDay())
-	outDir := filepath.Join(s.outDir, "datafiles", "evt", doyDir)
-	if err = os.MkdirAll(outDir, os.ModePerm); err != nil {
+	if err = os.MkdirAll(filepath.Dir(outPath), s.opts.dirMode()); err != nil {
 		return fmt.Errorf("sfl: %v", err)
 	}
-	outPath := filepath.Join(outDir, filepath.Base(s.paths[rec.idx]))
 	if s.file == nil || s.file.Name() != outPath {
-		if err = s.Close(); err != nil {
+		if err = s.closeFile(); err != nil {
+			return err
+		}
+		flag := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+		if s.truncate[outPath] {
+			flag |= os.O_TRUNC
+			delete(s.truncate, outPath)
+		}
+		if s.file, err = os.OpenFile(outPath, flag, s.opts.fileMode()); err != nil {
 			return fmt.Errorf("sfl: %v", err)
 		}
-		if s.file, err = os.OpenFile(outPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.ModePerm); err != nil {
+		s.written[outPath] = true
+		if s.opts.Compress {
+			s.gz = gzip.NewWriter(s.file)
+		}
+		fi, err := s.file.Stat()
+		if err != nil {
+			return fmt.Errorf("sfl: %v", err)
+		}
+		if fi.Size() == 0 {
+			// Start a new output file with its source's header, wherever its
+			// first emitted record falls. Re-opening a file when records from
+			// several sources interleave doesn't repeat it.
+			if err = s.write(s.headers[rec.idx]); err != nil {
+				return err
+			}
+		}
+	}
+	if err = s.write(rec.data); err != nil {
+		return err
+	}
+	if s.opts.Fsync {
+		if s.gz != nil {
+			if err = s.gz.Flush(); err != nil {
+				return fmt.Errorf("sfl: %v", err)
+			}
+		}
+		if err = s.file.Sync(); err != nil {
 			return fmt.Errorf("sfl: %v", err)
 		}
 	}
-	s.file.WriteString(fmt.Sprintf("%s\r\n", rec.data))
 	return
 }
 
+// write writes line with a CRLF ending to the current output file.
+func (s *Sfl) write(line string) (err error) {
+	var w io.Writer = s.file
+	if s.gz != nil {
+		w = s.gz
+	}
+	n, err := io.WriteString(w, line+"\r\n")
+	s.output.add(int64(n))
+	if err != nil {
+		return fmt.Errorf("sfl: %v", err)
+	}
+	return nil
+}
+
+// outPath returns the output path for the SFL file rec belongs to, by default
+// in the day of year directory of the file's timestamp. Gzipped input files
+// are written uncompressed unless opts.Compress is set.
+func (s *Sfl) outPath(rec sflRecord) (string, error) {
+	outFileTime, err := timeFromFilename(s.paths[rec.idx], s.opts.TZ)
+	if err != nil {
+		return "", err
+	}
+	outFileTime = s.opts.shift(outFileTime)
+	base := strings.TrimSuffix(filepath.Base(s.paths[rec.idx]), ".gz")
+	if s.opts.Compress {
+		base += ".gz"
+	}
+	return s.opts.outputPath(s.outDir, "sfl", filepath.Join("datafiles", "evt"), outFileTime, base)
+}
+
+func (s *Sfl) Target() string {
+	if s.i < 0 {
+		return ""
+	}
+	if s.opts.NoFiles {
+		return s.opts.UDP.String()
+	}
+	outPath, err := s.outPath(s.data[s.i])
+	if err != nil {
+		return ""
+	}
+	return outPath
+}
+
+// Seek positions the feed so that the next call to Next moves to the first
+// record at or after t. It reports whether any such record exists.
+func (s *Sfl) Seek(t time.Time) bool {
+	idx := sort.Search(len(s.data), func(i int) bool {
+		return !s.data[i].time.Before(t)
+	})
+	s.i = idx - 1
+	s.progress.set(idx)
+	return idx < len(s.data)
+}
+
 func (s *Sfl) Time() (t time.Time) {
 	if s.i >= 0 && len(s.data) > 0 {
 		t = s.data[s.i].time
@@ -152,6 +440,7 @@ func (s *Sfl) Time() (t time.Time) {
 func (s *Sfl) Next() bool {
 	if s.i+1 < len(s.data) {
 		s.i++
+		s.progress.set(s.i + 1)
 		return true
 	}
 	return false
@@ -161,6 +450,11 @@ func (s *Sfl) Warnings() []Warning {
 	return s.warnings
 }
 
+// Duplicates returns the number of rows dropped by opts.Dedupe.
+func (s *Sfl) Duplicates() int {
+	return s.dupes
+}
+
 func (s *Sfl) Name() string {
 	return "sfl"
 }
@@ -169,11 +463,28 @@ func (s *Sfl) Len() int {
 	return len(s.data)
 }
 
-// sfl is one data line of an SFL file with a header line prepended if this is
-// the first line in a file.
+func (s *Sfl) Times() []time.Time {
+	ts := make([]time.Time, len(s.data))
+	for i, rec := range s.data {
+		ts[i] = rec.time
+	}
+	return ts
+}
+
+func (s *Sfl) Progress() (done int, total int) {
+	return s.progress.get(), len(s.data)
+}
+
+func (s *Sfl) Stats() FeedStats {
+	return statsOf(s, &s.output)
+}
+
+// sflRecord is one data line of an SFL file. The file's header is kept in
+// Sfl.headers and written when its output file is started.
 type sflRecord struct {
 	time time.Time
-	idx  int
+	idx  int // index of the input file in paths
+	line int // line number in the input file
 	data string
 }
 
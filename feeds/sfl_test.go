@@ -0,0 +1,365 @@
+package feeds
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sflHeader = "DATE\tFILE_DURATION\tLAT\tLON\tCONDUCTIVITY\tSALINITY\tOCEAN_TEMP\tPAR\tBULK_RED\tSTREAM_PRESSURE\tEVENT_RATE"
+
+// sflRow returns an SFL data line at ts, an offset timestamp like
+// 2021-01-01T00:00:00+00:00.
+func sflRow(ts string) string {
+	return ts + "\t180\t21.3\t-157.8\t5.1\t34.8\t25.2\t0\t0.1\t12.1\t1000"
+}
+
+// writeSflFile writes an SFL file with sflHeader and rows to dir/name, where
+// name is timestamped like 2021-01-01T00-00-00+00-00.sfl.
+func writeSflFile(t *testing.T, dir, name string, rows ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	lines := append([]string{sflHeader}, rows...)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// interleavedSfl writes two SFL files whose records alternate in time and
+// returns their paths.
+func interleavedSfl(t *testing.T, dir string) []string {
+	t.Helper()
+	a := writeSflFile(t, dir, "2021-01-01T00-00-00+00-00.sfl",
+		sflRow("2021-01-01T00:00:00+00:00"), sflRow("2021-01-01T00:02:00+00:00"))
+	b := writeSflFile(t, dir, "2021-01-01T00-01-00+00-00.sfl",
+		sflRow("2021-01-01T00:01:00+00:00"), sflRow("2021-01-01T00:03:00+00:00"))
+	return []string{a, b}
+}
+
+// readSflOutputs returns the lines of each SFL output file under out, by
+// file name.
+func readSflOutputs(t *testing.T, out string) map[string][]string {
+	t.Helper()
+	files := map[string][]string{}
+	dir := filepath.Join(out, "datafiles", "evt", "2021_001")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, ent.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[ent.Name()] = strings.Split(strings.TrimSuffix(string(b), "\r\n"), "\r\n")
+	}
+	return files
+}
+
+func TestSflClose(t *testing.T) {
+	f := writeSflFile(t, t.TempDir(), "2021-01-01T00-00-00+00-00.sfl",
+		sflRow("2021-01-01T00:00:00+00:00"), sflRow("2021-01-01T00:03:00+00:00"))
+	s, err := NewSfl([]string{f}, t.TempDir(), SflOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Next()
+	if err := s.Emit(); err != nil {
+		t.Fatal(err)
+	}
+	out := s.file
+	if out == nil {
+		t.Fatal("no output file open after Emit")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if s.file != nil {
+		t.Error("output file still set after Close")
+	}
+	if _, err := out.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("write to the output file after Close = %v, want %v", err, os.ErrClosed)
+	}
+}
+
+// emitAll emits every remaining record of e, failing the test on an error.
+func emitAll(t *testing.T, e Emitter) {
+	t.Helper()
+	for e.Next() {
+		if err := e.Emit(); err != nil {
+			t.Fatalf("Emit at %v: %v", e.Time(), err)
+		}
+	}
+}
+
+func TestSflLargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes and replays a 14MB SFL file")
+	}
+	in := t.TempDir()
+	name := "2021-01-01T00-00-00+00-00.sfl"
+	path := filepath.Join(in, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer // SFL output lines end in CRLF
+	w := bufio.NewWriter(f)
+	w.WriteString(sflHeader + "\n")
+	want.WriteString(sflHeader + "\r\n")
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 200000; i++ {
+		row := sflRow(start.Add(time.Duration(i) * time.Second).Format("2006-01-02T15:04:05-07:00"))
+		w.WriteString(row + "\n")
+		want.WriteString(row + "\r\n")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("bounded memory", func(t *testing.T) {
+		// Keep no records so the heap only holds what reading needs. HeapSys
+		// doesn't shrink, so it covers the peak.
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		_, err := NewSfl([]string{path}, t.TempDir(), SflOptions{Options: Options{To: start.Add(-time.Second)}})
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grew := int64(after.HeapSys) - int64(before.HeapSys); grew > fi.Size()/2 {
+			t.Errorf("heap grew %d bytes reading a %d byte file", grew, fi.Size())
+		}
+	})
+
+	t.Run("byte-identical output", func(t *testing.T) {
+		out := t.TempDir()
+		s, err := NewSfl([]string{path}, out, SflOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		emitAll(t, s)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(out, "datafiles", "evt", "2021_001", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("output of %d bytes differs from the input's %d", len(got), want.Len())
+		}
+	})
+}
+
+func TestSflBadSecondLine(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	name := "2021-01-01T00-00-00+00-00.sfl"
+	f := writeSflFile(t, in, name, sflRow("2021-01-01T0x:00:00+00:00"), "garbage",
+		sflRow("2021-01-01T00:03:00+00:00"), sflRow("2021-01-01T00:06:00+00:00"))
+	s, err := NewSfl([]string{f}, out, SflOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := s.Warnings(); len(w) != 2 || !strings.Contains(w[0].String(), f+":2 ") || !strings.HasSuffix(w[1].String(), f+":3") {
+		t.Errorf("warnings = %v, want a bad timestamp on line 2 and an unparsable line 3", s.Warnings())
+	}
+	emitAll(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		name: {sflHeader, sflRow("2021-01-01T00:03:00+00:00"), sflRow("2021-01-01T00:06:00+00:00")},
+	}
+	if got := readSflOutputs(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("outputs = %q, want %q", got, want)
+	}
+}
+
+func TestSflGzipMatchesPlain(t *testing.T) {
+	plainDir, gzDir := t.TempDir(), t.TempDir()
+	name := "2021-01-01T00-00-00+00-00.sfl"
+	plain := writeSflFile(t, plainDir, name,
+		sflRow("2021-01-01T00:00:00+00:00"), "not a record", sflRow("2021-01-01T00:03:00+00:00"))
+	data, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(data)
+	zw.Close()
+	gz := filepath.Join(gzDir, name+".gz")
+	if err := os.WriteFile(gz, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(path string) (*Sfl, []byte) {
+		out := t.TempDir()
+		s, err := NewSfl([]string{path}, out, SflOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		emitAll(t, s)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(out, "datafiles", "evt", "2021_001", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s, got
+	}
+	if files, err := FindSFLFiles(gzDir); err != nil || len(files) != 1 || files[0] != gz {
+		t.Fatalf("FindSFLFiles = %v, %v, want [%s]", files, err, gz)
+	}
+	ps, pout := read(plain)
+	gs, gout := read(gz)
+	if len(gs.data) != len(ps.data) {
+		t.Fatalf("gzipped file has %d records, want %d", len(gs.data), len(ps.data))
+	}
+	for i := range ps.data {
+		g, p := gs.data[i], ps.data[i]
+		if !g.time.Equal(p.time) || g.data != p.data || g.line != p.line {
+			t.Errorf("gzipped record %d = %v line %d, want %v line %d", i, g, g.line, p, p.line)
+		}
+	}
+	if len(gs.Warnings()) != len(ps.Warnings()) {
+		t.Errorf("gzipped file has %d warnings, want %d", len(gs.Warnings()), len(ps.Warnings()))
+	}
+	if !bytes.Equal(gout, pout) {
+		t.Errorf("gzipped output:\n%s\nwant:\n%s", gout, pout)
+	}
+}
+
+func TestSflInterleavedHeadersOnce(t *testing.T) {
+	a, b := "2021-01-01T00-00-00+00-00.sfl", "2021-01-01T00-01-00+00-00.sfl"
+	tests := []struct {
+		name   string
+		seek   string // RFC3339 time to seek to first, "" to start at the beginning
+		passes int
+		want   map[string][]string
+	}{
+		{
+			name:   "one pass",
+			passes: 1,
+			want: map[string][]string{
+				a: {sflHeader, sflRow("2021-01-01T00:00:00+00:00"), sflRow("2021-01-01T00:02:00+00:00")},
+				b: {sflHeader, sflRow("2021-01-01T00:01:00+00:00"), sflRow("2021-01-01T00:03:00+00:00")},
+			},
+		},
+		{
+			name:   "seek past a file's first record",
+			seek:   "2021-01-01T00:01:00Z",
+			passes: 1,
+			want: map[string][]string{
+				a: {sflHeader, sflRow("2021-01-01T00:02:00+00:00")},
+				b: {sflHeader, sflRow("2021-01-01T00:01:00+00:00"), sflRow("2021-01-01T00:03:00+00:00")},
+			},
+		},
+		{
+			name:   "looped",
+			passes: 2,
+			want: map[string][]string{
+				a: {sflHeader, sflRow("2021-01-01T00:00:00+00:00"), sflRow("2021-01-01T00:02:00+00:00")},
+				b: {sflHeader, sflRow("2021-01-01T00:01:00+00:00"), sflRow("2021-01-01T00:03:00+00:00")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := t.TempDir()
+			s, err := NewSfl(interleavedSfl(t, t.TempDir()), out, SflOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for pass := 0; pass < tt.passes; pass++ {
+				if pass > 0 {
+					if err := s.Reset(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if tt.seek != "" {
+					at, err := time.Parse(time.RFC3339, tt.seek)
+					if err != nil {
+						t.Fatal(err)
+					}
+					s.Seek(at)
+				}
+				emitAll(t, s)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got := readSflOutputs(t, out)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outputs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSflManifestRecordsEachFileOnce(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	files := interleavedSfl(t, in)
+	m, err := NewManifest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSfl(files, out, SflOptions{Options: Options{Manifest: m}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emitAll(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(out, "manifest.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")[1:]
+	if len(lines) != 2 {
+		t.Fatalf("manifest has %d lines, want one per output file:\n%s", len(lines), b)
+	}
+	for _, line := range lines {
+		cols := strings.Split(line, "\t")
+		data, err := os.ReadFile(filepath.Join(out, cols[2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if cols[3] != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: manifest checksum isn't the finished file's", cols[2])
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+package feeds
+
+import "syscall"
+
+// setBroadcast enables SO_BROADCAST on a socket before it's connected.
+func setBroadcast(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// setMulticastTTL returns a dialer control function that sets the IPv4
+// multicast TTL of a socket.
+func setMulticastTTL(ttl int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
@@ -0,0 +1,155 @@
+package feeds
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IsTarArchive reports whether path names a tar archive, plain or gzipped,
+// by its extension.
+func IsTarArchive(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewEvtArchive creates an EVT feed from the members of a tar archive, plain
+// or gzipped, that are named like EVT files. Other members are skipped.
+// Members are read straight from the archive when they're emitted, so it
+// never has to be unpacked. Archives whose members are in time order are read
+// in one pass; otherwise the archive is reread from the start when a member
+// comes before the last one read.
+func NewEvtArchive(archive string, outDir string, opts EvtOptions) (e *Evt, err error) {
+	e, err = NewEvt(nil, outDir, opts)
+	if err != nil {
+		return e, err
+	}
+	e.archive = &tarSource{path: archive}
+	defer e.archive.Close()
+
+	for entry := 0; ; entry++ {
+		hdr, err := e.archive.nextHeader()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return e, fmt.Errorf("evt: %s: %v", archive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !isEVTName(path.Base(hdr.Name)) {
+			continue
+		}
+		e.found = append(e.found, archive+":"+hdr.Name)
+		t, err := timeFromFilename(hdr.Name, opts.TZ)
+		if err != nil {
+			e.warnings = append(e.warnings, Warning{err: fmt.Errorf("evt: skipping %s:%s, bad timestamp: %v", archive, hdr.Name, err), feed: "evt", kind: WarnTimestamp, file: archive + ":" + hdr.Name})
+			if err := opts.checkWarnings(e.warnings); err != nil {
+				return e, err
+			}
+			continue
+		}
+		if !opts.keep(opts.shift(t)) {
+			continue
+		}
+		if opts.MtimeSkew > 0 {
+			if w, ok := checkMtime(archive+":"+hdr.Name, hdr.ModTime, t, opts.MtimeSkew); !ok {
+				e.warnings = append(e.warnings, w)
+				if err := opts.checkWarnings(e.warnings); err != nil {
+					return e, err
+				}
+			}
+		}
+		e.data = append(e.data, evtFile{path: hdr.Name, time: opts.shift(t), entry: entry})
+	}
+
+	sort.SliceStable(e.data, func(i, j int) bool {
+		if !e.data[i].time.Equal(e.data[j].time) {
+			return e.data[i].time.Before(e.data[j].time)
+		}
+		return e.data[i].path < e.data[j].path
+	})
+	e.sample()
+
+	return e, nil
+}
+
+// tarSource reads members of a tar archive by position, keeping the archive
+// open between reads so members read in order cost one pass.
+type tarSource struct {
+	path string
+	f    *os.File
+	gz   *gzip.Reader
+	tr   *tar.Reader
+	next int // position of the member the next call to tr.Next returns
+}
+
+// open returns a reader for the member at position entry.
+func (ts *tarSource) open(entry int) (io.Reader, error) {
+	if ts.tr == nil || entry < ts.next {
+		if err := ts.Close(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if _, err := ts.nextHeader(); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%s: member %d is missing", ts.path, entry)
+			}
+			return nil, err
+		}
+		if ts.next-1 == entry {
+			return ts.tr, nil
+		}
+	}
+}
+
+// nextHeader opens the archive if needed and moves to the next member.
+func (ts *tarSource) nextHeader() (*tar.Header, error) {
+	if ts.tr == nil {
+		f, err := os.Open(ts.path)
+		if err != nil {
+			return nil, err
+		}
+		ts.f = f
+		var r io.Reader = f
+		if !strings.HasSuffix(ts.path, ".tar") {
+			if ts.gz, err = gzip.NewReader(f); err != nil {
+				f.Close()
+				ts.f = nil
+				return nil, fmt.Errorf("%s: %v", ts.path, err)
+			}
+			r = ts.gz
+		}
+		// A plain file is an io.Seeker, so tar skips unread members cheaply
+		ts.tr = tar.NewReader(r)
+		ts.next = 0
+	}
+	hdr, err := ts.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	ts.next++
+	return hdr, nil
+}
+
+// Close closes the archive. It's reopened by the next read.
+func (ts *tarSource) Close() (err error) {
+	if ts.gz != nil {
+		ts.gz.Close()
+		ts.gz = nil
+	}
+	if ts.f != nil {
+		err = ts.f.Close()
+		ts.f = nil
+	}
+	ts.tr = nil
+	return err
+}
@@ -0,0 +1,49 @@
+package feeds
+
+import "time"
+
+// typeThrottle rate-limits underway records like cruisemic's parse.Throttle,
+// which the parser applies per parser feed, but lets some record types have
+// their own interval. Types without an override share the parser feed's
+// throttle at the default interval, as they would in the parser.
+type typeThrottle struct {
+	interval  time.Duration            // default interval
+	overrides map[string]time.Duration // by record type, e.g. GPGGA, or NMEA sentence ID, e.g. GGA
+	recent    map[string]time.Time     // time of the last record kept, by throttle key
+}
+
+func newTypeThrottle(interval time.Duration, overrides map[string]time.Duration) *typeThrottle {
+	return &typeThrottle{interval: interval, overrides: overrides, recent: make(map[string]time.Time)}
+}
+
+// keep reports whether a record of type typ from parser feed feed at t passes
+// the throttle, and if so makes it the latest kept record of its kind. As in
+// cruisemic, a record earlier than the last one kept always passes, so one bad
+// timestamp far in the future can't hold back everything after it.
+func (th *typeThrottle) keep(typ string, feed string, t time.Time) bool {
+	key, interval := "feed:"+feed, th.interval
+	if d, ok := th.override(typ); ok {
+		key, interval = "type:"+typ, d
+	}
+	last, seen := th.recent[key]
+	if seen {
+		if diff := t.Sub(last); diff >= 0 && diff < interval {
+			return false
+		}
+	}
+	th.recent[key] = t
+	return true
+}
+
+// override returns the interval for record type typ if one was given, by its
+// full type or, for an NMEA sentence, by its sentence ID without the talker.
+func (th *typeThrottle) override(typ string) (time.Duration, bool) {
+	if d, ok := th.overrides[typ]; ok {
+		return d, true
+	}
+	if len(typ) == 5 {
+		d, ok := th.overrides[typ[2:]]
+		return d, ok
+	}
+	return 0, false
+}
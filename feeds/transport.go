@@ -0,0 +1,245 @@
+package feeds
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Transport describes a network, serial, or stdout destination for a streamed
+// feed.
+type Transport struct {
+	Proto     string // "udp", "tcp", "serial", or "stdout"
+	Host      string
+	Port      uint
+	Interface string // outbound network interface name for multicast
+	TTL       int    // multicast TTL, 0 for the system default
+	Device    string // serial device path
+	Baud      int    // serial baud rate
+	Discard   bool   // don't open the destination, drop everything written
+}
+
+// Addr returns the host:port destination address, or the device path for
+// serial transports.
+func (t Transport) Addr() string {
+	if t.Proto == "serial" {
+		return t.Device
+	}
+	return net.JoinHostPort(t.Host, strconv.FormatUint(uint64(t.Port), 10))
+}
+
+func (t Transport) String() string {
+	if t.Proto == "stdout" {
+		return "stdout"
+	}
+	if t.Proto == "serial" {
+		return fmt.Sprintf("serial:%s:%d", t.Device, t.Baud)
+	}
+	return t.Proto + "://" + t.Addr()
+}
+
+// Open connects to the destination. TCP connections are re-established with
+// backoff if the peer drops, serial devices are reopened if they're busy or
+// unplugged.
+func (t Transport) Open() (io.WriteCloser, error) {
+	if t.Discard {
+		return discardConn{}, nil
+	}
+	if t.Proto == "stdout" {
+		return stdoutConn{}, nil
+	}
+	if t.Proto == "serial" {
+		c := &serialConn{device: t.Device, baud: t.Baud}
+		if err := c.open(); err != nil && !isBusy(err) {
+			return nil, err
+		}
+		// A busy device may be released later, writes retry the open
+		return c, nil
+	}
+	ip := net.ParseIP(t.Host)
+	multicast := ip != nil && ip.IsMulticast()
+	if !multicast && (t.Interface != "" || t.TTL != 0) {
+		return nil, fmt.Errorf("multicast interface and TTL require a multicast host (224.0.0.0/4), got %v", t.Host)
+	}
+	switch t.Proto {
+	case "udp":
+		if multicast {
+			return t.openMulticast(ip)
+		}
+		if isBroadcast(net.ParseIP(t.Host)) {
+			// The Go runtime enables SO_BROADCAST on UDP sockets on most
+			// platforms but don't rely on it, sends fail with EACCES without it.
+			d := net.Dialer{Control: setBroadcast}
+			return d.Dial("udp4", t.Addr())
+		}
+		return net.Dial("udp", t.Addr())
+	case "tcp":
+		if multicast {
+			return nil, fmt.Errorf("can't use multicast host %v with tcp", t.Host)
+		}
+		c := &tcpConn{addr: t.Addr()}
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", t.Proto)
+	}
+}
+
+// openMulticast opens a UDP socket sending to multicast group ip, optionally
+// through a specific interface and with a non-default TTL.
+func (t Transport) openMulticast(ip net.IP) (io.WriteCloser, error) {
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 multicast is supported, got %v", t.Host)
+	}
+	if t.TTL < 0 || t.TTL > 255 {
+		return nil, fmt.Errorf("multicast TTL must be 0 (system default) or 1-255, got %d", t.TTL)
+	}
+	d := net.Dialer{}
+	if t.Interface != "" {
+		// Sending from one of the interface's addresses makes the kernel route
+		// the group's traffic through that interface
+		laddr, err := interfaceAddr(t.Interface)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = &net.UDPAddr{IP: laddr}
+	}
+	if t.TTL > 0 {
+		d.Control = setMulticastTTL(t.TTL)
+	}
+	return d.Dial("udp4", t.Addr())
+}
+
+// interfaceAddr returns the first IPv4 address of the multicast capable
+// network interface name.
+func interfaceAddr(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("multicast interface: %v", err)
+	}
+	if iface.Flags&net.FlagMulticast == 0 {
+		return nil, fmt.Errorf("multicast interface: %v does not support multicast", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("multicast interface: %v", err)
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("multicast interface: %v has no IPv4 address", name)
+}
+
+// isBroadcast reports whether ip is the limited broadcast address or the
+// directed broadcast address of a local IPv4 network.
+func isBroadcast(ip net.IP) bool {
+	ip = ip.To4()
+	if ip == nil {
+		return false
+	}
+	if ip.Equal(net.IPv4bcast) {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		local, mask := ipnet.IP.To4(), ipnet.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		bcast := make(net.IP, net.IPv4len)
+		for i := range bcast {
+			bcast[i] = local[i] | ^mask[i]
+		}
+		if ip.Equal(bcast) && !ip.Equal(local) {
+			return true
+		}
+	}
+	return false
+}
+
+// discardConn drops everything written to it.
+type discardConn struct{}
+
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (discardConn) Close() error {
+	return nil
+}
+
+// stdoutConn writes to standard output and leaves it open on Close.
+type stdoutConn struct{}
+
+func (stdoutConn) Write(b []byte) (int, error) {
+	return os.Stdout.Write(b)
+}
+
+func (stdoutConn) Close() error {
+	return nil
+}
+
+// tcpDialAttempts is how many times tcpConn tries to connect before giving up
+// on a write.
+const tcpDialAttempts = 5
+
+// tcpConn is a TCP connection that reconnects when a write fails, e.g. because
+// the consumer restarted.
+type tcpConn struct {
+	addr string
+	conn net.Conn
+}
+
+func (c *tcpConn) dial() (err error) {
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		if c.conn, err = net.Dial("tcp", c.addr); err == nil {
+			return nil
+		}
+		if attempt == tcpDialAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (c *tcpConn) Write(b []byte) (n int, err error) {
+	if c.conn == nil {
+		if err = c.dial(); err != nil {
+			return 0, err
+		}
+	}
+	if n, err = c.conn.Write(b); err == nil {
+		return n, nil
+	}
+	// Assume the peer dropped (EOF, reset, broken pipe), reconnect and resend
+	c.conn.Close()
+	c.conn = nil
+	if err = c.dial(); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(b)
+}
+
+func (c *tcpConn) Close() (err error) {
+	if c.conn != nil {
+		err = c.conn.Close()
+		c.conn = nil
+	}
+	return
+}
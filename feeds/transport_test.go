@@ -0,0 +1,46 @@
+package feeds
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTransportBroadcast(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.LocalAddr().(*net.UDPAddr).Port
+
+	for _, host := range []string{"127.255.255.255", "255.255.255.255"} {
+		t.Run(host, func(t *testing.T) {
+			if !isBroadcast(net.ParseIP(host)) {
+				t.Skipf("%s isn't a broadcast address here", host)
+			}
+			c, err := Transport{Proto: "udp", Host: host, Port: uint(port)}.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			msg := "broadcast to " + host
+			if _, err := c.Write([]byte(msg)); errors.Is(err, syscall.ENETUNREACH) && host == "255.255.255.255" {
+				t.Skipf("no route for the limited broadcast address: %v", err)
+			} else if err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			l.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 1500)
+			n, _, err := l.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("no datagram received: %v", err)
+			}
+			if string(buf[:n]) != msg {
+				t.Errorf("received %q, want %q", buf[:n], msg)
+			}
+		})
+	}
+}
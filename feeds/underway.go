@@ -3,12 +3,15 @@ package feeds
 import (
 	"bufio"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ctberthiaume/cruisemic/parse"
@@ -16,35 +19,190 @@ import (
 
 type Underway struct {
 	i        int // index of next item to emit
+	progress progress
+	output   byteCount // bytes written or sent
 	data     []underwayRecord
-	conn     net.Conn
+	conn     io.WriteCloser
+	connMu   sync.Mutex // serializes writes to conn and tee, which heartbeats share
+	dest     Transport
+	teeFile  *os.File      // copy of every payload sent, nil for none
+	tee      *bufio.Writer // buffers writes to teeFile
+	opts     UnderwayOptions
+	dupes    int           // duplicate records dropped by opts.Dedupe
+	throttle *typeThrottle // per-type throttle when opts.TypeThrottles is set, else nil
 	warnings []Warning
 }
 
-func NewUnderway(file string, host string, port uint, throttleSec int64) (u *Underway, err error) {
-	u = &Underway{i: -1}
+// UnderwayOptions configures an Underway feed.
+type UnderwayOptions struct {
+	Options
+	FixChecksum bool          // recompute the checksum of NMEA sentences before sending
+	CoalesceAll bool          // merge all records in the same second, not just those of one type
+	NoCoalesce  bool          // send every record on its own at its original time
+	Subsecond   bool          // time a coalesced record by its first line, not the start of its second
+	SplitLines  bool          // send each line of a coalesced record in its own write
+	SplitDelay  time.Duration // pause between split or size-limited writes
+	MaxPayload  int           // split records into writes of at most this many bytes, 0 for no limit
+	Retries     int           // retries of a write that fails with a transient error
+	Tee         string        // also append every payload sent to this file, "" for none
+	Sample      int           // keep only every Sample'th record, for testing, 0 or 1 for all
+	// Throttle intervals for particular record types, by type, e.g. GPGGA, or
+	// NMEA sentence ID, e.g. GGA. Other types get the feed's throttle.
+	TypeThrottles map[string]time.Duration
+}
+
+// NewUnderway creates an underway feed from files which sends records to dest.
+// Records from all files are parsed in order by a single parser then sorted
+// together. parserName is a key in cruisemic's parse.ParserRegistry.
+func NewUnderway(files []string, dest Transport, parserName string, throttleSec int64, opts UnderwayOptions) (u *Underway, err error) {
+	u = &Underway{i: -1, dest: dest, opts: opts}
 	u.data = []underwayRecord{}
-	u.conn, err = net.Dial("udp", fmt.Sprintf("%v:%d", host, port))
+	parserFact, ok := parse.ParserRegistry[parserName]
+	if !ok {
+		return u, fmt.Errorf("underway: unknown parser %q, choose from %q", parserName, ParserNames())
+	}
+	u.conn, err = dest.Open()
 	if err != nil {
 		return u, fmt.Errorf("underway: %v", err)
 	}
-
-	parserFact, ok := parse.ParserRegistry["Kilo Moana"]
-	if !ok {
-		panic(fmt.Errorf("invalid parser choice"))
+	if opts.Tee != "" {
+		if u.teeFile, err = os.OpenFile(opts.Tee, os.O_CREATE|os.O_APPEND|os.O_WRONLY, opts.fileMode()); err != nil {
+			return u, fmt.Errorf("underway: %v", err)
+		}
+		u.tee = bufio.NewWriter(u.teeFile)
 	}
+
 	throttle := time.Duration(throttleSec * int64(time.Second))
+	if len(opts.TypeThrottles) > 0 {
+		// Throttle after parsing, when the record type is known
+		u.throttle = newTypeThrottle(throttle, opts.TypeThrottles)
+		throttle = 0
+	}
 	parser := parserFact("", throttle) // rate limit to one record type per minute
+	for idx, file := range files {
+		if err = u.readFile(idx, file, parser); err != nil {
+			return u, err
+		}
+	}
+
+	// Sort by time, ascending, then by input file and line number
+	sort.SliceStable(u.data, func(i, j int) bool {
+		a, b := u.data[i], u.data[j]
+		if !a.time.Equal(b.time) {
+			return a.time.Before(b.time)
+		}
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		return a.line < b.line
+	})
+
+	u.dedupe()
+	if !opts.NoCoalesce {
+		u.coalesce()
+	}
+	u.sample()
+
+	return u, nil
+}
+
+// sample keeps every opts.Sample'th record of the sorted, coalesced records,
+// starting with the first.
+func (u *Underway) sample() {
+	if u.opts.Sample <= 1 {
+		return
+	}
+	kept := u.data[:0]
+	for i := 0; i < len(u.data); i += u.opts.Sample {
+		kept = append(kept, u.data[i])
+	}
+	u.data = kept
+}
+
+// dedupe drops records with the same time and line as an earlier one, if
+// opts.Dedupe is set. It runs before coalescing so repeats are caught line by
+// line.
+func (u *Underway) dedupe() {
+	if !u.opts.Dedupe {
+		return
+	}
+	keep := uniqueRecords(len(u.data), func(i int) (time.Time, string) { return u.data[i].time, u.data[i].data })
+	kept := make([]underwayRecord, len(keep))
+	for j, i := range keep {
+		kept[j] = u.data[i]
+	}
+	u.dupes = len(u.data) - len(kept)
+	u.data = kept
+}
+
+// coalesce merges records with identical times to the second into one
+// newline-delimited record. Unless opts.CoalesceAll is set only records of the
+// same type are merged, so each emitted record holds a single type. Merged
+// records keep the order their first line appeared in. A merged record's time
+// is the start of its second, or with opts.Subsecond the time of its first
+// line.
+func (u *Underway) coalesce() {
+	if len(u.data) == 0 {
+		return
+	}
+	newdata := []underwayRecord{}
+	for start := 0; start < len(u.data); {
+		t := u.data[start].time.Truncate(time.Second)
+		end := start + 1
+		for end < len(u.data) && u.data[end].time.Truncate(time.Second).Equal(t) {
+			end++
+		}
+		var types []string
+		lines := make(map[string][]string)
+		first := make(map[string]time.Time)
+		for _, rec := range u.data[start:end] {
+			typ := rec.typ
+			if u.opts.CoalesceAll {
+				typ = ""
+			}
+			if _, ok := lines[typ]; !ok {
+				types = append(types, typ)
+				first[typ] = rec.time
+			}
+			lines[typ] = append(lines[typ], rec.data)
+		}
+		for _, typ := range types {
+			rt := t
+			if u.opts.Subsecond {
+				rt = first[typ]
+			}
+			newdata = append(newdata, underwayRecord{time: rt, typ: typ, data: strings.Join(lines[typ], "\n")})
+		}
+		start = end
+	}
+	u.data = newdata
+}
+
+// recordType returns the NMEA talker and sentence ID of line, e.g. GPGGA, or
+// feed, the parser's record type, for non-NMEA lines.
+func recordType(line string, feed string) string {
+	if len(line) > 0 && (line[0] == '$' || line[0] == '!') {
+		if end := strings.IndexAny(line, ",*"); end > 0 {
+			return line[1:end]
+		}
+		return line[1:]
+	}
+	return feed
+}
+
+// readFile parses an underway feed file, the idx'th input file, optionally
+// gzipped, and appends its records to u.data.
+func (u *Underway) readFile(idx int, file string, parser parse.Parser) (err error) {
 	f, err := os.Open(file)
 	if err != nil {
-		return
+		return fmt.Errorf("underway: %v", err)
 	}
 	defer f.Close()
 	var r io.Reader
 	if strings.HasSuffix(file, ".gz") {
 		r, err = gzip.NewReader(f)
 		if err != nil {
-			return u, fmt.Errorf("underway: %v", err)
+			return fmt.Errorf("underway: %s: %v", file, err)
 		}
 	} else {
 		r = bufio.NewReader(f)
@@ -60,52 +218,66 @@ func NewUnderway(file string, host string, port uint, throttleSec int64) (u *Und
 
 		d, err := parser.ParseLine(line)
 		if err != nil {
-			newErr := fmt.Errorf("underway: line %d: %v", i, err)
-			u.warnings = append(u.warnings, Warning{err: newErr})
+			newErr := fmt.Errorf("underway: %s:%d: %v", file, i, err)
+			u.warnings = append(u.warnings, Warning{err: newErr, feed: "underway", kind: WarnParse, file: file, line: i})
+			if err = u.opts.checkWarnings(u.warnings); err != nil {
+				return err
+			}
 		} else if d.OK() {
-			u.data = append(u.data, underwayRecord{time: d.Time, data: line})
+			typ := recordType(line, d.Feed)
+			if u.throttle != nil && !u.throttle.keep(typ, d.Feed, d.Time) {
+				continue
+			}
+			if t := u.opts.shift(d.Time); u.opts.keep(t) {
+				u.data = append(u.data, underwayRecord{time: t, typ: typ, file: idx, line: i, data: line})
+			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return u, fmt.Errorf("underway: %v", err)
+		return fmt.Errorf("underway: %s: %v", file, err)
 	}
+	return
+}
 
-	// Sort by time, ascending
-	sort.SliceStable(u.data, func(i, j int) bool {
-		return u.data[i].time.Before(u.data[j].time)
-	})
-
-	// Coalesce records with identical times to the second
-	if len(u.data) > 0 {
-		newdata := []underwayRecord{}
-		t := u.data[0].time.Truncate(time.Second)
-		lines := []string{u.data[0].data}
-		for i := 1; i < len(u.data); i++ {
-			if u.data[i].time.Truncate(time.Second).Equal(t) {
-				lines = append(lines, u.data[i].data)
-			} else {
-				newdata = append(newdata, underwayRecord{time: t, data: strings.Join(lines, "\n")})
-				lines = lines[:0]
-				lines = append(lines, u.data[i].data)
-				t = u.data[i].time.Truncate(time.Second)
-			}
-		}
-		u.data = append(newdata, underwayRecord{time: t, data: strings.Join(lines, "\n")})
+// ParserNames returns the sorted names of available underway parsers.
+func ParserNames() []string {
+	names := make([]string, 0, len(parse.ParserRegistry))
+	for k := range parse.ParserRegistry {
+		names = append(names, k)
 	}
-
-	return u, nil
+	sort.Strings(names)
+	return names
 }
 
 func (u *Underway) Close() (err error) {
+	u.connMu.Lock()
+	defer u.connMu.Unlock()
 	if u.conn != nil {
 		if err = u.conn.Close(); err != nil {
-			return fmt.Errorf("underway: %v", err)
+			err = fmt.Errorf("underway: %v", err)
+		}
+		u.conn = nil
+	}
+	if u.teeFile != nil {
+		teeErr := u.tee.Flush()
+		if closeErr := u.teeFile.Close(); teeErr == nil {
+			teeErr = closeErr
+		}
+		u.teeFile = nil
+		if teeErr != nil && err == nil {
+			err = fmt.Errorf("underway: --udp-tee: %v", teeErr)
 		}
 	}
 
 	return
 }
 
+func (u *Underway) Reset() (err error) {
+	u.i = -1
+	u.progress.set(0)
+	return
+}
+
 func (u *Underway) Earliest() (t time.Time) {
 	if len(u.data) > 0 {
 		t = u.data[0].time
@@ -113,16 +285,178 @@ func (u *Underway) Earliest() (t time.Time) {
 	return
 }
 
+func (u *Underway) Latest() (t time.Time) {
+	if len(u.data) > 0 {
+		t = u.data[len(u.data)-1].time
+	}
+	return
+}
+
 func (u *Underway) Emit() (err error) {
 	if u.i < 0 {
 		return
 	}
-	if _, err = u.conn.Write([]byte(u.data[u.i].data + "\n")); err != nil {
-		return fmt.Errorf("underway: %v", err)
+	lines := strings.Split(u.data[u.i].data, "\n")
+	if u.opts.FixChecksum {
+		for i, line := range lines {
+			lines[i] = fixNMEAChecksum(line)
+		}
+	}
+	var payloads []string
+	switch {
+	case u.opts.SplitLines:
+		for _, line := range lines {
+			payloads = append(payloads, line+"\n")
+		}
+	case u.opts.MaxPayload > 0:
+		payloads = packLines(lines, u.opts.MaxPayload)
+	default:
+		payloads = []string{strings.Join(lines, "\n") + "\n"}
+	}
+	oversized := 0
+	for i, p := range payloads {
+		if i > 0 && u.opts.SplitDelay > 0 {
+			time.Sleep(u.opts.SplitDelay)
+		}
+		if u.opts.MaxPayload > 0 && len(p) > u.opts.MaxPayload {
+			oversized++
+		}
+		if err = u.write(p); err != nil {
+			return err
+		}
+	}
+	if oversized > 0 {
+		return Warning{feed: "underway", kind: WarnIO, err: fmt.Errorf("underway: %d line(s) at %v longer than the %d byte payload limit sent anyway",
+			oversized, u.data[u.i].time, u.opts.MaxPayload)}
 	}
 	return
 }
 
+// write sends p, retrying with backoff up to opts.Retries times if the write
+// fails with a transient error. A payload still failing after every retry is
+// dropped and reported as a Warning.
+func (u *Underway) write(p string) (err error) {
+	backoff := 10 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if err = u.send(p); err == nil {
+			return nil
+		}
+		if !isTransient(err) {
+			return fmt.Errorf("underway: %v", err)
+		}
+		if attempt == u.opts.Retries {
+			return Warning{err: fmt.Errorf("underway: dropped record at %v after %d retries: %v", u.data[u.i].time, attempt, err), feed: "underway", kind: WarnIO}
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send writes p to the connection and the tee file, if any.
+func (u *Underway) send(p string) (err error) {
+	u.connMu.Lock()
+	defer u.connMu.Unlock()
+	n, err := u.conn.Write([]byte(p))
+	u.output.add(int64(n))
+	if err != nil {
+		return err
+	}
+	return u.teeWrite(p)
+}
+
+// SendHeartbeat sends p on the feed's connection outside the data schedule,
+// without retries. It's safe to call while the feed is emitting, and does
+// nothing once the feed is closed.
+func (u *Underway) SendHeartbeat(p string) (err error) {
+	u.connMu.Lock()
+	defer u.connMu.Unlock()
+	if u.conn == nil {
+		return nil
+	}
+	n, err := u.conn.Write([]byte(p))
+	u.output.add(int64(n))
+	if err != nil {
+		return fmt.Errorf("underway: heartbeat: %v", err)
+	}
+	return u.teeWrite(p)
+}
+
+// teeWrite appends a sent payload to the tee file, if any, after a line with
+// the send time and the payload's length in bytes.
+func (u *Underway) teeWrite(p string) (err error) {
+	if u.tee == nil {
+		return nil
+	}
+	if _, err = fmt.Fprintf(u.tee, "%s %d\n%s", time.Now().UTC().Format(time.RFC3339Nano), len(p), p); err != nil {
+		return fmt.Errorf("underway: --udp-tee: %v", err)
+	}
+	return nil
+}
+
+// isTransient reports whether a write error may succeed if retried, such as
+// full socket buffers under burst load or a timeout.
+func isTransient(err error) bool {
+	if errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// packLines joins newline-terminated lines into as few payloads of at most max
+// bytes as possible without breaking a line. A line longer than max gets a
+// payload of its own.
+func packLines(lines []string, max int) (payloads []string) {
+	var b strings.Builder
+	for _, line := range lines {
+		if b.Len() > 0 && b.Len()+len(line)+1 > max {
+			payloads = append(payloads, b.String())
+			b.Reset()
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	if b.Len() > 0 {
+		payloads = append(payloads, b.String())
+	}
+	return payloads
+}
+
+// fixNMEAChecksum sets the *HH checksum of an NMEA sentence starting with $
+// or ! to the XOR of the characters between the start and the *, adding one if
+// it's missing. Other lines are returned unchanged.
+func fixNMEAChecksum(line string) string {
+	if len(line) == 0 || (line[0] != '$' && line[0] != '!') {
+		return line
+	}
+	body := line[1:]
+	if star := strings.IndexByte(body, '*'); star >= 0 {
+		body = body[:star]
+	} else {
+		body = strings.TrimRight(body, "\r")
+	}
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("%c%s*%02X", line[0], body, sum)
+}
+
+func (u *Underway) Target() string {
+	return u.dest.String()
+}
+
+// Seek positions the feed so that the next call to Next moves to the first
+// record at or after t. It reports whether any such record exists.
+func (u *Underway) Seek(t time.Time) bool {
+	idx := sort.Search(len(u.data), func(i int) bool {
+		return !u.data[i].time.Before(t)
+	})
+	u.i = idx - 1
+	u.progress.set(idx)
+	return idx < len(u.data)
+}
+
 func (u *Underway) Time() (t time.Time) {
 	if u.i >= 0 && len(u.data) > 0 {
 		t = u.data[u.i].time
@@ -133,6 +467,7 @@ func (u *Underway) Time() (t time.Time) {
 func (u *Underway) Next() bool {
 	if u.i+1 < len(u.data) {
 		u.i++
+		u.progress.set(u.i + 1)
 		return true
 	}
 	return false
@@ -142,6 +477,11 @@ func (u *Underway) Warnings() []Warning {
 	return u.warnings
 }
 
+// Duplicates returns the number of records dropped by opts.Dedupe.
+func (u *Underway) Duplicates() int {
+	return u.dupes
+}
+
 func (u *Underway) Name() string {
 	return "underway"
 }
@@ -150,8 +490,27 @@ func (u *Underway) Len() int {
 	return len(u.data)
 }
 
+func (u *Underway) Times() []time.Time {
+	ts := make([]time.Time, len(u.data))
+	for i, rec := range u.data {
+		ts[i] = rec.time
+	}
+	return ts
+}
+
+func (u *Underway) Progress() (done int, total int) {
+	return u.progress.get(), len(u.data)
+}
+
+func (u *Underway) Stats() FeedStats {
+	return statsOf(u, &u.output)
+}
+
 type underwayRecord struct {
 	time time.Time
+	typ  string // record type, records of different types aren't coalesced
+	file int    // index of the input file, before coalescing
+	line int    // line number in the input file, before coalescing
 	data string
 }
 
@@ -6,4 +6,5 @@ require (
 	github.com/ctberthiaume/cruisemic v0.2.2
 	github.com/seaflow-uw/seaflog v0.1.1
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
 )